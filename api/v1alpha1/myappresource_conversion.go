@@ -0,0 +1,393 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/sumyann/k8s-controller/api/v1beta1"
+)
+
+// specAnnotation stores the v1alpha1 spec on v1beta1 objects when it cannot be
+// represented by v1beta1, e.g. the deprecated resource fields or the settings
+// of a disabled Redis. It is used to restore those fields when converting back.
+const specAnnotation = "my.api.group.my.api.group/v1alpha1-spec"
+
+var _ conversion.Convertible = &MyAppResource{}
+
+// ConvertTo converts this MyAppResource to the Hub version (v1beta1).
+func (src *MyAppResource) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.MyAppResource)
+	in := src.DeepCopy()
+
+	dst.ObjectMeta = in.ObjectMeta
+	dst.Spec = specToHub(&in.Spec)
+	dst.Status = statusToHub(&in.Status)
+
+	// Keep a copy of the spec when converting back would not restore it
+	var roundTripped MyAppResourceSpec
+	specFromHub(dst.Spec.DeepCopy(), &roundTripped)
+	if equality.Semantic.DeepEqual(roundTripped, src.Spec) {
+		delete(dst.Annotations, specAnnotation)
+		return nil
+	}
+
+	data, err := json.Marshal(src.Spec)
+	if err != nil {
+		return err
+	}
+	if dst.Annotations == nil {
+		dst.Annotations = map[string]string{}
+	}
+	dst.Annotations[specAnnotation] = string(data)
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *MyAppResource) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.MyAppResource)
+	in := src.DeepCopy()
+
+	dst.ObjectMeta = in.ObjectMeta
+	specFromHub(&in.Spec, &dst.Spec)
+	dst.Status = statusFromHub(&in.Status)
+
+	data, ok := dst.Annotations[specAnnotation]
+	if !ok {
+		return nil
+	}
+	delete(dst.Annotations, specAnnotation)
+	if len(dst.Annotations) == 0 {
+		dst.Annotations = nil
+	}
+
+	var stashed MyAppResourceSpec
+	if err := json.Unmarshal([]byte(data), &stashed); err != nil {
+		return err
+	}
+	restoreSpec(&dst.Spec, &stashed, &src.Spec)
+	return nil
+}
+
+// restoreSpec copies the parts of the stashed v1alpha1 spec that v1beta1
+// cannot represent into spec, as long as the hub object still matches them.
+// Anything changed through v1beta1 since the spec was stashed wins.
+func restoreSpec(spec, stashed *MyAppResourceSpec, hub *v1beta1.MyAppResourceSpec) {
+	if equality.Semantic.DeepEqual(resourcesToHub(stashed.Resources), hub.Podinfo.Resources) {
+		spec.Resources = stashed.Resources
+	}
+	if equality.Semantic.DeepEqual(redisToHub(stashed.Redis), hub.Cache.Redis) {
+		spec.Redis = stashed.Redis
+	}
+	if equality.Semantic.DeepEqual(cacheServerToHub(stashed.CacheServer), hub.Cache.External) {
+		spec.CacheServer = stashed.CacheServer
+	}
+	if equality.Semantic.DeepEqual(ingressToHub(stashed.Ingress), hub.Ingress) {
+		spec.Ingress = stashed.Ingress
+	}
+}
+
+func specToHub(in *MyAppResourceSpec) v1beta1.MyAppResourceSpec {
+	return v1beta1.MyAppResourceSpec{
+		Podinfo: v1beta1.PodinfoSpec{
+			Replicas: in.ReplicaCount,
+			Image: v1beta1.ImageSpec{
+				Repository: in.Image.Repository,
+				Tag:        in.Image.Tag,
+			},
+			Resources: resourcesToHub(in.Resources),
+			UI: v1beta1.UISpec{
+				Color:   in.UI.Color,
+				Message: in.UI.Message,
+			},
+			Env:     in.Env,
+			EnvFrom: in.EnvFrom,
+			Rollout: v1beta1.RolloutSpec(in.Rollout),
+		},
+		Cache: v1beta1.CacheSpec{
+			Redis:    redisToHub(in.Redis),
+			External: cacheServerToHub(in.CacheServer),
+		},
+		Service: v1beta1.ServiceSpec{
+			Type:            in.Service.Type,
+			Port:            in.Service.Port,
+			NodePort:        in.Service.NodePort,
+			Annotations:     in.Service.Annotations,
+			SessionAffinity: in.Service.SessionAffinity,
+			IPFamilies:      in.Service.IPFamilies,
+		},
+		Ingress: ingressToHub(in.Ingress),
+		Deletion: v1beta1.DeletionSpec{
+			Policy:            v1beta1.DeletionPolicy(in.Deletion.Policy),
+			SnapshotClaimName: in.Deletion.SnapshotClaimName,
+		},
+		Paused:          in.Paused,
+		RestartAt:       in.RestartAt,
+		RestartSchedule: in.RestartSchedule,
+	}
+}
+
+func specFromHub(in *v1beta1.MyAppResourceSpec, out *MyAppResourceSpec) {
+	out.ReplicaCount = in.Podinfo.Replicas
+	out.Image = Image{
+		Repository: in.Podinfo.Image.Repository,
+		Tag:        in.Podinfo.Image.Tag,
+	}
+	out.Resources = resourcesFromHub(in.Podinfo.Resources)
+	out.UI = UI{
+		Color:   in.Podinfo.UI.Color,
+		Message: in.Podinfo.UI.Message,
+	}
+	out.Env = in.Podinfo.Env
+	out.EnvFrom = in.Podinfo.EnvFrom
+	out.Rollout = Rollout(in.Podinfo.Rollout)
+
+	out.Redis = Redis{}
+	if in.Cache.Redis != nil {
+		out.Redis = Redis{
+			Enabled:      true,
+			ReplicaCount: in.Cache.Redis.Replicas,
+			Resources:    resourcesFromHub(in.Cache.Redis.Resources),
+		}
+	}
+	out.CacheServer = CServer{}
+	if in.Cache.External != nil {
+		out.CacheServer = CServer{
+			Enabled: true,
+			Host:    in.Cache.External.Host,
+			Port:    int(in.Cache.External.Port),
+		}
+	}
+
+	out.Service = Service{
+		Type:            in.Service.Type,
+		Port:            in.Service.Port,
+		NodePort:        in.Service.NodePort,
+		Annotations:     in.Service.Annotations,
+		SessionAffinity: in.Service.SessionAffinity,
+		IPFamilies:      in.Service.IPFamilies,
+	}
+
+	out.Ingress = Ingress{}
+	if in.Ingress != nil {
+		out.Ingress = Ingress{
+			Enabled:       true,
+			ClassName:     in.Ingress.ClassName,
+			Hosts:         in.Ingress.Hosts,
+			Paths:         in.Ingress.Paths,
+			TLSSecretName: in.Ingress.TLSSecretName,
+			Annotations:   in.Ingress.Annotations,
+		}
+	}
+
+	out.Deletion = Deletion{
+		Policy:            DeletionPolicy(in.Deletion.Policy),
+		SnapshotClaimName: in.Deletion.SnapshotClaimName,
+	}
+	out.Paused = in.Paused
+	out.RestartAt = in.RestartAt
+	out.RestartSchedule = in.RestartSchedule
+}
+
+func redisToHub(in Redis) *v1beta1.RedisSpec {
+	if !in.Enabled {
+		return nil
+	}
+	return &v1beta1.RedisSpec{
+		Replicas:  in.ReplicaCount,
+		Resources: resourcesToHub(in.Resources),
+	}
+}
+
+func cacheServerToHub(in CServer) *v1beta1.ExternalCacheSpec {
+	if !in.Enabled {
+		return nil
+	}
+	return &v1beta1.ExternalCacheSpec{
+		Host: in.Host,
+		Port: int32(in.Port),
+	}
+}
+
+func ingressToHub(in Ingress) *v1beta1.IngressSpec {
+	if !in.Enabled {
+		return nil
+	}
+	return &v1beta1.IngressSpec{
+		ClassName:     in.ClassName,
+		Hosts:         in.Hosts,
+		Paths:         in.Paths,
+		TLSSecretName: in.TLSSecretName,
+		Annotations:   in.Annotations,
+	}
+}
+
+// resourcesToHub converts the string based resources, applying the legacy
+// memoryLimit and cpuRequest fields the same way the controller does.
+// Quantities that do not parse are left out, they are kept in specAnnotation.
+func resourcesToHub(in ResourceRequirements) corev1.ResourceRequirements {
+	requests := in.Requests
+	if requests.CPU == "" {
+		requests.CPU = in.CPURequest
+	}
+	limits := in.Limits
+	if limits.Memory == "" {
+		limits.Memory = in.MemoryLimit
+	}
+	return corev1.ResourceRequirements{
+		Requests: resourceListToHub(requests),
+		Limits:   resourceListToHub(limits),
+	}
+}
+
+func resourceListToHub(in ResourceList) corev1.ResourceList {
+	var out corev1.ResourceList
+	for name, value := range map[corev1.ResourceName]string{
+		corev1.ResourceCPU:              in.CPU,
+		corev1.ResourceMemory:           in.Memory,
+		corev1.ResourceEphemeralStorage: in.EphemeralStorage,
+	} {
+		if value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			continue
+		}
+		if out == nil {
+			out = corev1.ResourceList{}
+		}
+		out[name] = q
+	}
+	return out
+}
+
+func resourcesFromHub(in corev1.ResourceRequirements) ResourceRequirements {
+	return ResourceRequirements{
+		Requests: resourceListFromHub(in.Requests),
+		Limits:   resourceListFromHub(in.Limits),
+	}
+}
+
+func resourceListFromHub(in corev1.ResourceList) ResourceList {
+	var out ResourceList
+	if q, ok := in[corev1.ResourceCPU]; ok {
+		out.CPU = q.String()
+	}
+	if q, ok := in[corev1.ResourceMemory]; ok {
+		out.Memory = q.String()
+	}
+	if q, ok := in[corev1.ResourceEphemeralStorage]; ok {
+		out.EphemeralStorage = q.String()
+	}
+	return out
+}
+
+func statusToHub(in *MyAppResourceStatus) v1beta1.MyAppResourceStatus {
+	out := v1beta1.MyAppResourceStatus{
+		ObservedGeneration: in.ObservedGeneration,
+		Conditions:         in.Conditions,
+		Replicas:           in.Replicas,
+		Selector:           in.Selector,
+		Image:              in.Image,
+		DesiredReplicas:    in.DesiredReplicas,
+		ReadyReplicas:      in.ReadyReplicas,
+		UpdatedReplicas:    in.UpdatedReplicas,
+		Endpoint:           in.Endpoint,
+		Shard:              in.Shard,
+		LastHandledSyncNow: in.LastHandledSyncNow,
+		LastRestartTime:    in.LastRestartTime,
+	}
+	for _, pod := range in.Pods {
+		out.Pods = append(out.Pods, v1beta1.PodStatus(pod))
+	}
+	if in.Deletion != nil {
+		out.Deletion = &v1beta1.DeletionStatus{
+			Policy:       v1beta1.DeletionPolicy(in.Deletion.Policy),
+			Phase:        v1beta1.DeletionPhase(in.Deletion.Phase),
+			SnapshotFile: in.Deletion.SnapshotFile,
+			Message:      in.Deletion.Message,
+		}
+	}
+	if in.Rollout != nil {
+		rollout := v1beta1.RolloutStatus(*in.Rollout)
+		out.Rollout = &rollout
+	}
+	if in.Plan != nil {
+		out.Plan = &v1beta1.PlanStatus{ObservedGeneration: in.Plan.ObservedGeneration, Truncated: in.Plan.Truncated}
+		for _, change := range in.Plan.Changes {
+			out.Plan.Changes = append(out.Plan.Changes, v1beta1.PlannedChange{
+				Object:  change.Object,
+				Action:  v1beta1.PlannedAction(change.Action),
+				Field:   change.Field,
+				Live:    change.Live,
+				Desired: change.Desired,
+			})
+		}
+	}
+	return out
+}
+
+func statusFromHub(in *v1beta1.MyAppResourceStatus) MyAppResourceStatus {
+	out := MyAppResourceStatus{
+		ObservedGeneration: in.ObservedGeneration,
+		Conditions:         in.Conditions,
+		Replicas:           in.Replicas,
+		Selector:           in.Selector,
+		Image:              in.Image,
+		DesiredReplicas:    in.DesiredReplicas,
+		ReadyReplicas:      in.ReadyReplicas,
+		UpdatedReplicas:    in.UpdatedReplicas,
+		Endpoint:           in.Endpoint,
+		Shard:              in.Shard,
+		LastHandledSyncNow: in.LastHandledSyncNow,
+		LastRestartTime:    in.LastRestartTime,
+	}
+	for _, pod := range in.Pods {
+		out.Pods = append(out.Pods, PodStatus(pod))
+	}
+	if in.Deletion != nil {
+		out.Deletion = &DeletionStatus{
+			Policy:       DeletionPolicy(in.Deletion.Policy),
+			Phase:        DeletionPhase(in.Deletion.Phase),
+			SnapshotFile: in.Deletion.SnapshotFile,
+			Message:      in.Deletion.Message,
+		}
+	}
+	if in.Rollout != nil {
+		rollout := RolloutStatus(*in.Rollout)
+		out.Rollout = &rollout
+	}
+	if in.Plan != nil {
+		out.Plan = &PlanStatus{ObservedGeneration: in.Plan.ObservedGeneration, Truncated: in.Plan.Truncated}
+		for _, change := range in.Plan.Changes {
+			out.Plan.Changes = append(out.Plan.Changes, PlannedChange{
+				Object:  change.Object,
+				Action:  PlannedAction(change.Action),
+				Field:   change.Field,
+				Live:    change.Live,
+				Desired: change.Desired,
+			})
+		}
+	}
+	return out
+}
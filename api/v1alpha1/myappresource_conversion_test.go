@@ -0,0 +1,101 @@
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/sumyann/k8s-controller/api/v1beta1"
+)
+
+func TestConversionRoundTrip(t *testing.T) {
+	replicas := int32(2)
+	className := "nginx"
+	restartAt := metav1.Date(2023, 11, 2, 10, 0, 0, 0, time.UTC)
+	maxSurge := intstr.FromString("50%")
+	progressDeadline := int32(120)
+	for name, src := range map[string]*MyAppResource{
+		"defaulted": func() *MyAppResource {
+			r := validMyAppResource()
+			r.Default()
+			return r
+		}(),
+		"legacy resources and disabled blocks": {
+			ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default", Annotations: map[string]string{"team": "web"}},
+			Spec: MyAppResourceSpec{
+				ReplicaCount:    3,
+				Resources:       ResourceRequirements{MemoryLimit: "64Mi", CPURequest: "0.1"},
+				Image:           Image{Repository: "ghcr.io/stefanprodan/podinfo", Tag: "6.5.0"},
+				UI:              UI{Color: "#fff", Message: "hi"},
+				Redis:           Redis{Enabled: false, ReplicaCount: &replicas},
+				CacheServer:     CServer{Enabled: false, Host: "cache.example.com", Port: 6380},
+				Env:             []corev1.EnvVar{{Name: "FOO", Value: "bar"}},
+				EnvFrom:         []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "podinfo-config"}}}},
+				Ingress:         Ingress{Enabled: false, ClassName: &className, Hosts: []string{"podinfo.example.com"}},
+				Deletion:        Deletion{Policy: DeletionSnapshot, SnapshotClaimName: "redis-snapshots"},
+				Paused:          true,
+				RestartAt:       &restartAt,
+				RestartSchedule: "0 4 * * *",
+				Rollout:         Rollout{MaxSurge: &maxSurge, MinReadySeconds: 10, ProgressDeadlineSeconds: &progressDeadline, AutoRollback: true},
+			},
+			Status: MyAppResourceStatus{
+				ObservedGeneration: 4,
+				ReadyReplicas:      3,
+				Pods:               []PodStatus{{Name: "podinfo-a", Ready: true}},
+				Deletion:           &DeletionStatus{Policy: DeletionSnapshot, Phase: DeletionInProgress, SnapshotFile: "/snapshot/example-app.rdb"},
+				Shard:              "shard=a",
+				LastHandledSyncNow: "2023-11-02T10:00:00Z",
+				LastRestartTime:    &restartAt,
+				Rollout:            &RolloutStatus{LastGoodTemplateHash: "5d8f7c9b4", RolledBackGeneration: 4, LastRollbackTime: &restartAt, Reason: "ProgressDeadlineExceeded"},
+				Plan: &PlanStatus{ObservedGeneration: 4, Changes: []PlannedChange{
+					{Object: "Deployment/example-app-podinfo", Action: PlannedUpdate, Field: "spec.replicas", Live: "2", Desired: "3"},
+				}},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			hub := &v1beta1.MyAppResource{}
+			require.NoError(t, src.ConvertTo(hub))
+
+			dst := &MyAppResource{}
+			require.NoError(t, dst.ConvertFrom(hub))
+			require.Equal(t, src, dst)
+		})
+	}
+}
+
+func TestConversionToHub(t *testing.T) {
+	src := &MyAppResource{
+		Spec: MyAppResourceSpec{
+			ReplicaCount: 2,
+			Resources:    ResourceRequirements{MemoryLimit: "64Mi", Requests: ResourceList{CPU: "100m"}},
+			Redis:        Redis{Enabled: true},
+			CacheServer:  CServer{Enabled: true, Host: "cache.example.com"},
+		},
+	}
+
+	hub := &v1beta1.MyAppResource{}
+	require.NoError(t, src.ConvertTo(hub))
+	require.Equal(t, int32(2), hub.Spec.Podinfo.Replicas)
+	require.True(t, hub.Spec.Podinfo.Resources.Limits.Memory().Equal(resource.MustParse("64Mi")))
+	require.True(t, hub.Spec.Podinfo.Resources.Requests.Cpu().Equal(resource.MustParse("100m")))
+	require.NotNil(t, hub.Spec.Cache.Redis)
+	require.Equal(t, &v1beta1.ExternalCacheSpec{Host: "cache.example.com"}, hub.Spec.Cache.External)
+	require.Nil(t, hub.Spec.Ingress)
+	require.Contains(t, hub.Annotations, specAnnotation)
+
+	// Changes made through v1beta1 win over the stashed v1alpha1 spec
+	hub.Spec.Podinfo.Resources.Limits[corev1.ResourceMemory] = resource.MustParse("128Mi")
+	dst := &MyAppResource{}
+	require.NoError(t, dst.ConvertFrom(hub))
+	require.Equal(t, ResourceRequirements{
+		Requests: ResourceList{CPU: "100m"},
+		Limits:   ResourceList{Memory: "128Mi"},
+	}, dst.Spec.Resources)
+	require.Empty(t, dst.Annotations)
+}
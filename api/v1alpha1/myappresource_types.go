@@ -19,6 +19,7 @@ package v1alpha1
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -36,16 +37,158 @@ type MyAppResourceSpec struct {
 	Redis        Redis                `json:"redis"`
 	CacheServer  CServer              `json:"cacheServer"`
 	Env          []corev1.EnvVar      `json:"env,omitempty"`
+
+	// EnvFrom adds all keys of the listed ConfigMaps and Secrets to the
+	// podinfo environment. Changes to their data restart the pods.
+	// +optional
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+
+	// Service configures the Service that exposes podinfo.
+	// +optional
+	Service Service `json:"service,omitempty"`
+
+	// Ingress configures an Ingress routing to the podinfo Service.
+	// +optional
+	Ingress Ingress `json:"ingress,omitempty"`
+
+	// Deletion configures what happens to the children when the resource is deleted.
+	// +optional
+	Deletion Deletion `json:"deletion,omitempty"`
+
+	// Paused stops the controller from changing the children, for example to
+	// keep a manual hotfix in place. Their state is still reported in status.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// RestartAt restarts the podinfo and Redis pods, like kubectl rollout
+	// restart, once the time has passed. Set it to the current time to
+	// restart now.
+	// +optional
+	RestartAt *metav1.Time `json:"restartAt,omitempty"`
+
+	// RestartSchedule restarts the podinfo and Redis pods periodically, in
+	// cron format such as "0 4 * * *". A CRON_TZ=<zone> prefix selects the
+	// time zone, UTC is used otherwise.
+	// +optional
+	RestartSchedule string `json:"restartSchedule,omitempty"`
+
+	// Rollout configures the rollout strategy of the podinfo Deployment.
+	// +optional
+	Rollout Rollout `json:"rollout,omitempty"`
 }
 
 // MyAppResourceStatus defines the observed state of MyAppResource
 type MyAppResourceStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// ObservedGeneration is the most recent generation observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest available observations of the resource's state.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// Replicas is the number of podinfo pods, it backs the scale subresource.
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// Selector is the label selector of the podinfo pods, it backs the scale subresource.
+	// +optional
+	Selector string `json:"selector,omitempty"`
+
+	// Image is the image currently set on the podinfo Deployment.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// DesiredReplicas is the number of podinfo replicas requested by the spec.
+	// +optional
+	DesiredReplicas int32 `json:"desiredReplicas,omitempty"`
+
+	// ReadyReplicas is the number of podinfo pods with a Ready condition.
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// UpdatedReplicas is the number of podinfo pods running the current pod template.
+	// +optional
+	UpdatedReplicas int32 `json:"updatedReplicas,omitempty"`
+
+	// Endpoint is the URL at which the podinfo Service can be reached.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Pods lists the podinfo pods currently owned by this resource.
+	// +optional
+	Pods []PodStatus `json:"pods,omitempty"`
+
+	// Deletion reports the progress of spec.deletion once the resource is being deleted.
+	// +optional
+	Deletion *DeletionStatus `json:"deletion,omitempty"`
+
+	// Shard is the controller shard that reconciles the resource. A shard
+	// records itself before making changes, so a resource moved to another
+	// shard is taken over without both shards writing to it.
+	// +optional
+	Shard string `json:"shard,omitempty"`
+
+	// LastHandledSyncNow is the value of the sync-now annotation handled by
+	// the last full reconcile.
+	// +optional
+	LastHandledSyncNow string `json:"lastHandledSyncNow,omitempty"`
+
+	// LastRestartTime is the time of the last restart requested by
+	// spec.restartAt or spec.restartSchedule.
+	// +optional
+	LastRestartTime *metav1.Time `json:"lastRestartTime,omitempty"`
+
+	// Rollout reports the last successful podinfo rollout and the last
+	// rollback made by spec.rollout.autoRollback.
+	// +optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+
+	// Plan lists the changes the controller would make while the resource is
+	// in dry-run mode, it is removed when dry-run mode is turned off.
+	// +optional
+	Plan *PlanStatus `json:"plan,omitempty"`
+}
+
+// PodStatus describes a single podinfo pod
+type PodStatus struct {
+	Name     string          `json:"name"`
+	NodeName string          `json:"nodeName,omitempty"`
+	Phase    corev1.PodPhase `json:"phase,omitempty"`
+	Restarts int32           `json:"restarts"`
+	Ready    bool            `json:"ready"`
 }
 
+// Condition types reported in MyAppResourceStatus.Conditions
+const (
+	// ConditionAvailable means the podinfo Deployment has its minimum number of replicas available.
+	ConditionAvailable = "Available"
+	// ConditionProgressing means a podinfo rollout is in progress.
+	ConditionProgressing = "Progressing"
+	// ConditionDegraded means the controller failed to converge the resource or a rollout is stuck.
+	ConditionDegraded = "Degraded"
+	// ConditionCacheReady means the cache backend used by podinfo is ready.
+	ConditionCacheReady = "CacheReady"
+	// ConditionPaused means the controller does not change the children, see spec.paused.
+	ConditionPaused = "Paused"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:subresource:scale:specpath=.spec.replicaCount,statuspath=.status.replicas,selectorpath=.status.selector
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Desired",type=integer,JSONPath=`.spec.replicaCount`
+//+kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyReplicas`
+//+kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.status.image`
+//+kubebuilder:printcolumn:name="Redis",type=boolean,JSONPath=`.spec.redis.enabled`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // MyAppResource is the Schema for the myappresources API
 type MyAppResource struct {
@@ -67,8 +210,29 @@ type MyAppResourceList struct {
 
 // ResourceRequirements defines the resource requirements spec
 type ResourceRequirements struct {
-	MemoryLimit string `json:"memoryLimit"`
-	CPURequest  string `json:"cpuRequest"`
+	// MemoryLimit is kept for compatibility, it is used when limits.memory is not set.
+	// +optional
+	MemoryLimit string `json:"memoryLimit,omitempty"`
+	// CPURequest is kept for compatibility, it is used when requests.cpu is not set.
+	// +optional
+	CPURequest string `json:"cpuRequest,omitempty"`
+
+	// Requests describes the minimum amount of compute resources required.
+	// +optional
+	Requests ResourceList `json:"requests,omitempty"`
+	// Limits describes the maximum amount of compute resources allowed.
+	// +optional
+	Limits ResourceList `json:"limits,omitempty"`
+}
+
+// ResourceList defines quantities for each supported resource, e.g. "100m" or "64Mi"
+type ResourceList struct {
+	// +optional
+	CPU string `json:"cpu,omitempty"`
+	// +optional
+	Memory string `json:"memory,omitempty"`
+	// +optional
+	EphemeralStorage string `json:"ephemeralStorage,omitempty"`
 }
 
 // Image defines the image information
@@ -86,15 +250,215 @@ type UI struct {
 // Redis defines the Redis configuration
 type Redis struct {
 	Enabled bool `json:"enabled"`
+
+	// ReplicaCount is the number of Redis replicas, 0 or 1. Defaults to 1.
+	// Redis runs without replication, so more replicas are not supported.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	ReplicaCount *int32 `json:"replicaCount,omitempty"`
+
+	// Resources are the compute resources of the Redis container.
+	// +optional
+	Resources ResourceRequirements `json:"resources,omitempty"`
 }
 
-// Cache Server defines the Cache Server configuration
+// Cache Server defines the Cache Server configuration.
+// It is only used when Redis is not enabled, and sets PODINFO_CACHE_SERVER
+// to tcp://host:port.
 type CServer struct {
-	Enabled bool   `json:"enabled"`
-	Host    string `json:"host"`
-	Port    int    `json:"port"`
+	Enabled bool `json:"enabled"`
+	// +optional
+	Host string `json:"host,omitempty"`
+	// Port defaults to 6379.
+	// +optional
+	Port int `json:"port,omitempty"`
+}
+
+// Service defines the Service exposing podinfo
+type Service struct {
+	// Type defaults to ClusterIP.
+	// +optional
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	Type corev1.ServiceType `json:"type,omitempty"`
+	// Port defaults to 9898.
+	// +optional
+	Port int32 `json:"port,omitempty"`
+	// NodePort is only used with the NodePort and LoadBalancer types.
+	// A port is allocated by the cluster when it is not set.
+	// +optional
+	NodePort int32 `json:"nodePort,omitempty"`
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// +optional
+	// +kubebuilder:validation:Enum=None;ClientIP
+	SessionAffinity corev1.ServiceAffinity `json:"sessionAffinity,omitempty"`
+	// +optional
+	IPFamilies []corev1.IPFamily `json:"ipFamilies,omitempty"`
+}
+
+// Ingress defines the Ingress exposing the podinfo UI
+type Ingress struct {
+	Enabled bool `json:"enabled"`
+	// ClassName is the name of the IngressClass, the cluster default is used when it is not set.
+	// +optional
+	ClassName *string `json:"className,omitempty"`
+	// Hosts routed to podinfo. All hosts are matched when empty.
+	// +optional
+	Hosts []string `json:"hosts,omitempty"`
+	// Paths routed to podinfo with the Prefix path type. Defaults to "/".
+	// +optional
+	Paths []string `json:"paths,omitempty"`
+	// TLSSecretName enables TLS for all hosts using the certificate in this Secret.
+	// +optional
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Rollout configures how the podinfo Deployment rolls out a new pod template
+type Rollout struct {
+	// MaxSurge is the number or percentage of pods created above the desired
+	// replicas during a rollout, 25% when not set.
+	// +optional
+	// +kubebuilder:validation:XIntOrString
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+	// MaxUnavailable is the number or percentage of pods that may be
+	// unavailable during a rollout, 25% when not set.
+	// +optional
+	// +kubebuilder:validation:XIntOrString
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// MinReadySeconds is how long a new pod must be ready before it counts as
+	// available.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	MinReadySeconds int32 `json:"minReadySeconds,omitempty"`
+	// ProgressDeadlineSeconds is how long a rollout may make no progress
+	// before it is reported as failed, 600 when not set.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+	// AutoRollback rolls back to the last pod template that rolled out
+	// successfully when a rollout exceeds its progress deadline. The rollback
+	// is kept until the spec changes.
+	// +optional
+	AutoRollback bool `json:"autoRollback,omitempty"`
+}
+
+// RolloutStatus reports the podinfo rollouts
+type RolloutStatus struct {
+	// LastGoodTemplateHash is the pod-template-hash of the last podinfo
+	// ReplicaSet that rolled out successfully, it is the rollback target.
+	// +optional
+	LastGoodTemplateHash string `json:"lastGoodTemplateHash,omitempty"`
+	// RolledBackGeneration is the generation of the resource whose rollout
+	// was rolled back. The last good pod template is kept while the
+	// generation is unchanged.
+	// +optional
+	RolledBackGeneration int64 `json:"rolledBackGeneration,omitempty"`
+	// LastRollbackTime is the time of the last rollback.
+	// +optional
+	LastRollbackTime *metav1.Time `json:"lastRollbackTime,omitempty"`
+	// Reason is the reason of the failed rollout, such as ProgressDeadlineExceeded.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// Deletion defines how a MyAppResource is torn down
+type Deletion struct {
+	// Policy defaults to Cascade.
+	// +optional
+	Policy DeletionPolicy `json:"policy,omitempty"`
+	// SnapshotClaimName is the PersistentVolumeClaim the Redis snapshot is
+	// written to, it is required by the Snapshot policy.
+	// +optional
+	SnapshotClaimName string `json:"snapshotClaimName,omitempty"`
 }
 
+// DeletionPolicy selects what happens to the children of a MyAppResource when it is deleted
+// +kubebuilder:validation:Enum=Cascade;Orphan;Snapshot
+type DeletionPolicy string
+
+const (
+	// DeletionCascade lets the garbage collector delete all children.
+	DeletionCascade DeletionPolicy = "Cascade"
+	// DeletionOrphan keeps all children and removes their owner references.
+	DeletionOrphan DeletionPolicy = "Orphan"
+	// DeletionSnapshot saves the Redis data set before the children are deleted.
+	DeletionSnapshot DeletionPolicy = "Snapshot"
+)
+
+// DeletionPhase is the progress of the deletion policy
+type DeletionPhase string
+
+const (
+	// DeletionPending means the deletion policy is not carried out while the
+	// resource is in dry-run mode, the resource is kept until it is turned off.
+	DeletionPending DeletionPhase = "Pending"
+	// DeletionInProgress means the deletion policy is being carried out.
+	DeletionInProgress DeletionPhase = "InProgress"
+	// DeletionFailed means the deletion policy failed, the resource is kept
+	// until it succeeds or the policy is changed.
+	DeletionFailed DeletionPhase = "Failed"
+)
+
+// DeletionStatus reports the progress of the deletion policy
+type DeletionStatus struct {
+	Policy DeletionPolicy `json:"policy"`
+	Phase  DeletionPhase  `json:"phase"`
+	// SnapshotFile is the path of the Redis snapshot on the snapshot volume.
+	// +optional
+	SnapshotFile string `json:"snapshotFile,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// PlanStatus reports what the controller would change while the resource is
+// in dry-run mode
+type PlanStatus struct {
+	// ObservedGeneration is the generation of the resource the plan was made for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Changes are the changes a reconcile would make to the children, computed
+	// with a server-side dry-run. It is empty when the children match the spec.
+	// +optional
+	Changes []PlannedChange `json:"changes,omitempty"`
+	// Truncated is set when more changes were found than are recorded.
+	// +optional
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// PlannedChange is a change the controller would make to a child object
+type PlannedChange struct {
+	// Object is the kind and name of the child, such as Deployment/example-app-podinfo.
+	Object string `json:"object"`
+	// Action is Create, Update or Delete.
+	Action PlannedAction `json:"action"`
+	// Field is the path of the changed field of an updated object.
+	// +optional
+	Field string `json:"field,omitempty"`
+	// Live is the current value of the field, empty when it is not set.
+	// +optional
+	Live string `json:"live,omitempty"`
+	// Desired is the value of the field after the change, empty when it is removed.
+	// +optional
+	Desired string `json:"desired,omitempty"`
+}
+
+// PlannedAction is the kind of change planned for a child object
+// +kubebuilder:validation:Enum=Create;Update;Delete
+type PlannedAction string
+
+const (
+	// PlannedCreate means the child does not exist and would be created.
+	PlannedCreate PlannedAction = "Create"
+	// PlannedUpdate means a field of the child would be changed.
+	PlannedUpdate PlannedAction = "Update"
+	// PlannedDelete means the child is disabled in the spec and would be deleted.
+	PlannedDelete PlannedAction = "Delete"
+)
+
 func init() {
 	SchemeBuilder.Register(&MyAppResource{}, &MyAppResourceList{})
 }
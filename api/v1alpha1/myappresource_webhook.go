@@ -0,0 +1,372 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/sumyann/k8s-controller/internal/config"
+)
+
+// log is for logging in this package.
+var myappresourcelog = logf.Log.WithName("myappresource-resource")
+
+// Default values applied by the defaulting webhook
+const (
+	DefaultUIColor     = "#34577c"
+	DefaultUIMessage   = "Hello, Podinfo!"
+	DefaultRedisPort   = 6379
+	DefaultServicePort = 9898
+)
+
+// colorPattern matches the #rgb and #rrggbb forms accepted by PODINFO_UI_COLOR
+var colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// SetupWebhookWithManager will setup the manager to manage the webhooks. The
+// image and resources are defaulted from the current config of store.
+func (r *MyAppResource) SetupWebhookWithManager(mgr ctrl.Manager, store *config.Store) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&myAppResourceDefaulter{store: store}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-my-api-group-my-api-group-v1alpha1-myappresource,mutating=true,failurePolicy=fail,sideEffects=None,groups=my.api.group.my.api.group,resources=myappresources,verbs=create;update,versions=v1alpha1,name=mmyappresource.kb.io,admissionReviewVersions=v1
+
+// myAppResourceDefaulter defaults MyAppResources with the defaults of the
+// controller config, so the stored object shows what will run.
+type myAppResourceDefaulter struct {
+	store *config.Store
+}
+
+var _ admission.CustomDefaulter = &myAppResourceDefaulter{}
+
+// Default implements admission.CustomDefaulter so a webhook will be registered for the type
+func (d *myAppResourceDefaulter) Default(_ context.Context, obj runtime.Object) error {
+	r, ok := obj.(*MyAppResource)
+	if !ok {
+		return fmt.Errorf("expected a MyAppResource but got a %T", obj)
+	}
+	r.DefaultFrom(d.store.Get().Defaults)
+	return nil
+}
+
+// Default sets the defaults of the spec, with the built-in image and resources
+// of the controller config.
+func (r *MyAppResource) Default() {
+	r.DefaultFrom(config.Default().Defaults)
+}
+
+// DefaultFrom sets the defaults of the spec, with the image and resources of
+// defaults.
+func (r *MyAppResource) DefaultFrom(defaults config.Defaults) {
+	myappresourcelog.Info("default", "name", r.Name)
+
+	if r.Spec.Image.Repository == "" {
+		r.Spec.Image.Repository = defaults.Podinfo.Repository
+	}
+	if r.Spec.Image.Tag == "" {
+		r.Spec.Image.Tag = defaults.Podinfo.Tag
+	}
+
+	if r.Spec.Resources.isEmpty() {
+		r.Spec.Resources = resourcesFromHub(defaults.Podinfo.Resources)
+	}
+
+	if r.Spec.UI.Color == "" {
+		r.Spec.UI.Color = DefaultUIColor
+	}
+	if r.Spec.UI.Message == "" {
+		r.Spec.UI.Message = DefaultUIMessage
+	}
+
+	if r.Spec.Redis.Enabled {
+		if r.Spec.Redis.ReplicaCount == nil {
+			replicas := int32(1)
+			r.Spec.Redis.ReplicaCount = &replicas
+		}
+		if r.Spec.Redis.Resources.isEmpty() {
+			r.Spec.Redis.Resources = resourcesFromHub(defaults.Redis.Resources)
+		}
+	}
+
+	if r.Spec.CacheServer.Enabled && r.Spec.CacheServer.Port == 0 {
+		r.Spec.CacheServer.Port = DefaultRedisPort
+	}
+
+	if r.Spec.Service.Type == "" {
+		r.Spec.Service.Type = corev1.ServiceTypeClusterIP
+	}
+	if r.Spec.Service.Port == 0 {
+		r.Spec.Service.Port = DefaultServicePort
+	}
+	if r.Spec.Service.SessionAffinity == "" {
+		r.Spec.Service.SessionAffinity = corev1.ServiceAffinityNone
+	}
+
+	if r.Spec.Ingress.Enabled && len(r.Spec.Ingress.Paths) == 0 {
+		r.Spec.Ingress.Paths = []string{"/"}
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-my-api-group-my-api-group-v1alpha1-myappresource,mutating=false,failurePolicy=fail,sideEffects=None,groups=my.api.group.my.api.group,resources=myappresources,verbs=create;update,versions=v1alpha1,name=vmyappresource.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &MyAppResource{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *MyAppResource) ValidateCreate() (admission.Warnings, error) {
+	myappresourcelog.Info("validate create", "name", r.Name)
+
+	return r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *MyAppResource) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
+	myappresourcelog.Info("validate update", "name", r.Name)
+
+	// Removing the finalizer must not be blocked by a spec that was accepted
+	// before the current rules existed
+	if r.DeletionTimestamp != nil {
+		return nil, nil
+	}
+
+	return r.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *MyAppResource) ValidateDelete() (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (r *MyAppResource) validate() (admission.Warnings, error) {
+	var allErrs field.ErrorList
+	var warnings admission.Warnings
+	specPath := field.NewPath("spec")
+
+	if r.Spec.ReplicaCount < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("replicaCount"), r.Spec.ReplicaCount, "must be greater than or equal to 0"))
+	}
+
+	allErrs = append(allErrs, validateResources(r.Spec.Resources, specPath.Child("resources"))...)
+	warnings = append(warnings, resourceWarnings(r.Spec.Resources, specPath.Child("resources"))...)
+
+	if r.Spec.UI.Color != "" && !colorPattern.MatchString(r.Spec.UI.Color) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("ui", "color"), r.Spec.UI.Color, "must be a hex color such as #34577c"))
+	}
+
+	redisPath := specPath.Child("redis")
+	if r.Spec.Redis.ReplicaCount != nil && *r.Spec.Redis.ReplicaCount < 0 {
+		allErrs = append(allErrs, field.Invalid(redisPath.Child("replicaCount"), *r.Spec.Redis.ReplicaCount, "must be greater than or equal to 0"))
+	}
+	// The Redis pods do not replicate, each of them would hold its own data set
+	if r.Spec.Redis.ReplicaCount != nil && *r.Spec.Redis.ReplicaCount > 1 {
+		allErrs = append(allErrs, field.Invalid(redisPath.Child("replicaCount"), *r.Spec.Redis.ReplicaCount, "must be 0 or 1, Redis runs without replication"))
+	}
+	allErrs = append(allErrs, validateResources(r.Spec.Redis.Resources, redisPath.Child("resources"))...)
+	warnings = append(warnings, resourceWarnings(r.Spec.Redis.Resources, redisPath.Child("resources"))...)
+
+	cachePath := specPath.Child("cacheServer")
+	if r.Spec.CacheServer.Port < 0 || r.Spec.CacheServer.Port > 65535 {
+		allErrs = append(allErrs, field.Invalid(cachePath.Child("port"), r.Spec.CacheServer.Port, "must be between 1 and 65535"))
+	}
+	if r.Spec.CacheServer.Enabled && r.Spec.CacheServer.Host == "" {
+		allErrs = append(allErrs, field.Required(cachePath.Child("host"), "must be set when the cache server is enabled"))
+	}
+	if r.Spec.CacheServer.Enabled && r.Spec.Redis.Enabled {
+		warnings = append(warnings, fmt.Sprintf("%s is ignored while %s is true", cachePath, redisPath.Child("enabled")))
+	}
+
+	for i, envVar := range r.Spec.Env {
+		if envVar.Name == "PODINFO_CACHE_SERVER" {
+			warnings = append(warnings, fmt.Sprintf("%s: PODINFO_CACHE_SERVER is ignored, it is derived from %s and %s",
+				specPath.Child("env").Index(i), redisPath, cachePath))
+		}
+	}
+
+	servicePath := specPath.Child("service")
+	if r.Spec.Service.Port < 0 || r.Spec.Service.Port > 65535 {
+		allErrs = append(allErrs, field.Invalid(servicePath.Child("port"), r.Spec.Service.Port, "must be between 1 and 65535"))
+	}
+	if r.Spec.Service.NodePort < 0 || r.Spec.Service.NodePort > 65535 {
+		allErrs = append(allErrs, field.Invalid(servicePath.Child("nodePort"), r.Spec.Service.NodePort, "must be between 1 and 65535"))
+	}
+	if r.Spec.Service.NodePort != 0 && (r.Spec.Service.Type == "" || r.Spec.Service.Type == "ClusterIP") {
+		allErrs = append(allErrs, field.Forbidden(servicePath.Child("nodePort"), "may not be set when the service type is ClusterIP"))
+	}
+
+	ingressPath := specPath.Child("ingress")
+	if r.Spec.Ingress.Enabled {
+		for i, path := range r.Spec.Ingress.Paths {
+			if len(path) == 0 || path[0] != '/' {
+				allErrs = append(allErrs, field.Invalid(ingressPath.Child("paths").Index(i), path, "must be an absolute path"))
+			}
+		}
+		if r.Spec.Ingress.TLSSecretName != "" && len(r.Spec.Ingress.Hosts) == 0 {
+			warnings = append(warnings, fmt.Sprintf("%s is set but %s is empty, TLS will use the ingress controller's default host",
+				ingressPath.Child("tlsSecretName"), ingressPath.Child("hosts")))
+		}
+	}
+
+	deletionPath := specPath.Child("deletion")
+	if r.Spec.Deletion.Policy == DeletionSnapshot {
+		if r.Spec.Deletion.SnapshotClaimName == "" {
+			allErrs = append(allErrs, field.Required(deletionPath.Child("snapshotClaimName"), "must be set with the Snapshot policy"))
+		}
+		if !r.Spec.Redis.Enabled {
+			warnings = append(warnings, fmt.Sprintf("%s is Snapshot but %s is false, there is nothing to snapshot",
+				deletionPath.Child("policy"), redisPath.Child("enabled")))
+		}
+	}
+
+	if r.Spec.RestartSchedule != "" {
+		if _, err := cron.ParseStandard(r.Spec.RestartSchedule); err != nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("restartSchedule"), r.Spec.RestartSchedule, err.Error()))
+		}
+	}
+
+	allErrs = append(allErrs, validateRollout(r.Spec.Rollout, specPath.Child("rollout"))...)
+
+	if len(allErrs) == 0 {
+		return warnings, nil
+	}
+	return warnings, apierrors.NewInvalid(GroupVersion.WithKind("MyAppResource").GroupKind(), r.Name, allErrs)
+}
+
+// validateRollout applies the rules of the Deployment strategy, so an invalid
+// rollout is rejected here instead of failing every apply.
+func validateRollout(rollout Rollout, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	surge, surgeErrs := validateIntOrPercent(rollout.MaxSurge, fldPath.Child("maxSurge"))
+	allErrs = append(allErrs, surgeErrs...)
+	unavailable, unavailableErrs := validateIntOrPercent(rollout.MaxUnavailable, fldPath.Child("maxUnavailable"))
+	allErrs = append(allErrs, unavailableErrs...)
+	if rollout.MaxUnavailable != nil && rollout.MaxUnavailable.Type == intstr.String && unavailable > 100 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxUnavailable"), rollout.MaxUnavailable.String(), "must not be greater than 100%"))
+	}
+	if rollout.MaxSurge != nil && rollout.MaxUnavailable != nil && surge == 0 && unavailable == 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxUnavailable"), rollout.MaxUnavailable.String(), "may not be 0 when maxSurge is 0"))
+	}
+	if rollout.MinReadySeconds < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("minReadySeconds"), rollout.MinReadySeconds, "must be greater than or equal to 0"))
+	}
+	if deadline := rollout.ProgressDeadlineSeconds; deadline != nil && *deadline <= rollout.MinReadySeconds {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("progressDeadlineSeconds"), *deadline, "must be greater than minReadySeconds"))
+	}
+
+	return allErrs
+}
+
+// validateIntOrPercent checks that v is a non-negative number or percentage,
+// and returns its value.
+func validateIntOrPercent(v *intstr.IntOrString, fldPath *field.Path) (int, field.ErrorList) {
+	if v == nil {
+		return 0, nil
+	}
+	value, err := intstr.GetScaledValueFromIntOrPercent(v, 100, true)
+	if err != nil {
+		return 0, field.ErrorList{field.Invalid(fldPath, v.String(), "must be a number or a percentage such as 25%")}
+	}
+	if value < 0 {
+		return 0, field.ErrorList{field.Invalid(fldPath, v.String(), "must be greater than or equal to 0")}
+	}
+	return value, nil
+}
+
+// validateResources checks that every quantity parses and that no request
+// exceeds the matching limit.
+func validateResources(res ResourceRequirements, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	parse := func(value string, path *field.Path) *resource.Quantity {
+		if value == "" {
+			return nil
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(path, value, err.Error()))
+			return nil
+		}
+		if q.Sign() < 0 {
+			allErrs = append(allErrs, field.Invalid(path, value, "must be greater than or equal to 0"))
+		}
+		return &q
+	}
+
+	memoryLimit := parse(res.MemoryLimit, fldPath.Child("memoryLimit"))
+	cpuRequest := parse(res.CPURequest, fldPath.Child("cpuRequest"))
+
+	requestsPath, limitsPath := fldPath.Child("requests"), fldPath.Child("limits")
+	for _, pair := range []struct {
+		name           string
+		request, limit string
+		// legacy fields apply when the matching request or limit is not set
+		legacyRequest, legacyLimit *resource.Quantity
+	}{
+		{"cpu", res.Requests.CPU, res.Limits.CPU, cpuRequest, nil},
+		{"memory", res.Requests.Memory, res.Limits.Memory, nil, memoryLimit},
+		{"ephemeralStorage", res.Requests.EphemeralStorage, res.Limits.EphemeralStorage, nil, nil},
+	} {
+		request := parse(pair.request, requestsPath.Child(pair.name))
+		if pair.request == "" {
+			request = pair.legacyRequest
+		}
+		limit := parse(pair.limit, limitsPath.Child(pair.name))
+		if pair.limit == "" {
+			limit = pair.legacyLimit
+		}
+		if request != nil && limit != nil && request.Cmp(*limit) > 0 {
+			allErrs = append(allErrs, field.Invalid(requestsPath.Child(pair.name), request.String(),
+				fmt.Sprintf("must be less than or equal to %s limit of %s", pair.name, limit.String())))
+		}
+	}
+
+	return allErrs
+}
+
+// resourceWarnings flags the deprecated flat resource fields.
+func resourceWarnings(res ResourceRequirements, fldPath *field.Path) admission.Warnings {
+	var warnings admission.Warnings
+	if res.MemoryLimit != "" {
+		warnings = append(warnings, fmt.Sprintf("%s is deprecated, use %s instead",
+			fldPath.Child("memoryLimit"), fldPath.Child("limits", "memory")))
+	}
+	if res.CPURequest != "" {
+		warnings = append(warnings, fmt.Sprintf("%s is deprecated, use %s instead",
+			fldPath.Child("cpuRequest"), fldPath.Child("requests", "cpu")))
+	}
+	return warnings
+}
+
+func (res ResourceRequirements) isEmpty() bool {
+	return res.MemoryLimit == "" && res.CPURequest == "" &&
+		res.Requests == (ResourceList{}) && res.Limits == (ResourceList{})
+}
@@ -0,0 +1,137 @@
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/sumyann/k8s-controller/internal/config"
+)
+
+func validMyAppResource() *MyAppResource {
+	return &MyAppResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default"},
+		Spec: MyAppResourceSpec{
+			ReplicaCount: 2,
+			Resources: ResourceRequirements{
+				Requests: ResourceList{CPU: "100m", Memory: "32Mi"},
+				Limits:   ResourceList{Memory: "64Mi"},
+			},
+			Image: Image{Repository: "ghcr.io/stefanprodan/podinfo", Tag: "6.5.0"},
+			UI:    UI{Color: "#34577c", Message: "Hello, Podinfo!"},
+			Redis: Redis{Enabled: true},
+		},
+	}
+}
+
+func TestValidateCreate(t *testing.T) {
+	warnings, err := validMyAppResource().ValidateCreate()
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+
+	for name, mutate := range map[string]func(*MyAppResource){
+		"negative replicas":       func(r *MyAppResource) { r.Spec.ReplicaCount = -1 },
+		"unparsable memory limit": func(r *MyAppResource) { r.Spec.Resources.MemoryLimit = "64MB" },
+		"request above limit":     func(r *MyAppResource) { r.Spec.Resources.Requests.Memory = "128Mi" },
+		"non-hex color":           func(r *MyAppResource) { r.Spec.UI.Color = "blue" },
+		"cache port out of range": func(r *MyAppResource) { r.Spec.CacheServer.Port = 70000 },
+		"cache server without host": func(r *MyAppResource) {
+			r.Spec.Redis.Enabled = false
+			r.Spec.CacheServer.Enabled = true
+		},
+		"node port on ClusterIP":   func(r *MyAppResource) { r.Spec.Service.NodePort = 30098 },
+		"snapshot without claim":   func(r *MyAppResource) { r.Spec.Deletion.Policy = DeletionSnapshot },
+		"invalid restart schedule": func(r *MyAppResource) { r.Spec.RestartSchedule = "every day" },
+		"invalid max surge": func(r *MyAppResource) {
+			surge := intstr.FromString("half")
+			r.Spec.Rollout.MaxSurge = &surge
+		},
+		"replicated redis": func(r *MyAppResource) {
+			replicas := int32(2)
+			r.Spec.Redis.ReplicaCount = &replicas
+		},
+		"no surge and no unavailable pods": func(r *MyAppResource) {
+			zero := intstr.FromInt(0)
+			r.Spec.Rollout.MaxSurge, r.Spec.Rollout.MaxUnavailable = &zero, &zero
+		},
+		"progress deadline within min ready": func(r *MyAppResource) {
+			deadline := int32(10)
+			r.Spec.Rollout.MinReadySeconds, r.Spec.Rollout.ProgressDeadlineSeconds = 30, &deadline
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := validMyAppResource()
+			mutate(r)
+			_, err := r.ValidateCreate()
+			require.Error(t, err)
+			require.True(t, apierrors.IsInvalid(err))
+		})
+	}
+}
+
+func TestValidateUpdateWarnings(t *testing.T) {
+	old := validMyAppResource()
+	r := validMyAppResource()
+	r.Spec.Resources.CPURequest = "100m"
+	r.Spec.Env = []corev1.EnvVar{{Name: "PODINFO_CACHE_SERVER", Value: "tcp://redis:6379"}}
+
+	warnings, err := r.ValidateUpdate(old)
+	require.NoError(t, err)
+	require.Len(t, warnings, 2)
+	require.Contains(t, warnings[0], "spec.resources.cpuRequest is deprecated")
+	require.Contains(t, warnings[1], "spec.env[0]")
+
+	// A terminating object is not validated, so its finalizer can be removed
+	now := metav1.Now()
+	r.Spec.ReplicaCount = -1
+	r.DeletionTimestamp = &now
+	warnings, err = r.ValidateUpdate(old)
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+}
+
+func TestDefault(t *testing.T) {
+	r := &MyAppResource{Spec: MyAppResourceSpec{Redis: Redis{Enabled: true}}}
+	r.Default()
+
+	require.Equal(t, Image{Repository: config.DefaultImageRepository, Tag: config.DefaultImageTag}, r.Spec.Image)
+	require.Equal(t, "100m", r.Spec.Resources.Requests.CPU)
+	require.Equal(t, "64Mi", r.Spec.Resources.Limits.Memory)
+	require.Equal(t, UI{Color: DefaultUIColor, Message: DefaultUIMessage}, r.Spec.UI)
+	require.Equal(t, int32(1), *r.Spec.Redis.ReplicaCount)
+	require.Equal(t, "128Mi", r.Spec.Redis.Resources.Limits.Memory)
+	require.Equal(t, corev1.ServiceTypeClusterIP, r.Spec.Service.Type)
+	require.Equal(t, int32(DefaultServicePort), r.Spec.Service.Port)
+
+	// Defaulted objects must pass validation without warnings
+	warnings, err := r.ValidateCreate()
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+
+	// Explicit values, including the legacy resource fields, are kept
+	r = &MyAppResource{Spec: MyAppResourceSpec{Resources: ResourceRequirements{MemoryLimit: "256Mi"}}}
+	r.Default()
+	require.Equal(t, ResourceRequirements{MemoryLimit: "256Mi"}, r.Spec.Resources)
+}
+
+func TestDefaultFromConfig(t *testing.T) {
+	c := config.Default()
+	c.Defaults.Podinfo.Repository = "registry.example.com/podinfo"
+	c.Defaults.Redis.Resources = corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+	}
+	d := &myAppResourceDefaulter{store: config.NewStore("", c, logr.Discard())}
+
+	r := &MyAppResource{Spec: MyAppResourceSpec{Redis: Redis{Enabled: true}}}
+	require.NoError(t, d.Default(context.Background(), r))
+	require.Equal(t, Image{Repository: "registry.example.com/podinfo", Tag: config.DefaultImageTag}, r.Spec.Image)
+	require.Equal(t, "100m", r.Spec.Resources.Requests.CPU)
+	require.Equal(t, ResourceRequirements{Limits: ResourceList{Memory: "256Mi"}}, r.Spec.Redis.Resources)
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	uberzap "go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/sumyann/k8s-controller/internal/config"
+)
+
+// applyManagerConfig overrides the manager options set by flags with the
+// ones set in the config file. They only take effect on a restart.
+func applyManagerConfig(opts *ctrl.Options, c *config.Config) {
+	if c.Metrics.BindAddress != "" {
+		opts.Metrics.BindAddress = c.Metrics.BindAddress
+	}
+	if c.Health.ProbeBindAddress != "" {
+		opts.HealthProbeBindAddress = c.Health.ProbeBindAddress
+	}
+
+	leaderElection := c.LeaderElection
+	if leaderElection.LeaderElect != nil {
+		opts.LeaderElection = *leaderElection.LeaderElect
+	}
+	if leaderElection.LeaseDuration != nil {
+		opts.LeaseDuration = &leaderElection.LeaseDuration.Duration
+	}
+	if leaderElection.RenewDeadline != nil {
+		opts.RenewDeadline = &leaderElection.RenewDeadline.Duration
+	}
+	if leaderElection.RetryPeriod != nil {
+		opts.RetryPeriod = &leaderElection.RetryPeriod.Duration
+	}
+
+	if c.Controller.SyncPeriod != nil {
+		opts.Cache.SyncPeriod = &c.Controller.SyncPeriod.Duration
+	}
+}
+
+// applyLogLevel sets the log level of the config file, or the one of the
+// command line when the file does not set it.
+func applyLogLevel(level uberzap.AtomicLevel, flagLevel zapcore.Level, c *config.Config) {
+	if c.LogLevel == "" {
+		level.SetLevel(flagLevel)
+		return
+	}
+	// The level has been validated when the file was loaded
+	if l, err := c.Level(); err == nil {
+		level.SetLevel(l)
+	}
+}
@@ -17,22 +17,36 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"go.opentelemetry.io/otel"
+	uberzap "go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	myapigroupv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	myapigroupv1beta1 "github.com/sumyann/k8s-controller/api/v1beta1"
+	"github.com/sumyann/k8s-controller/internal/config"
 	"github.com/sumyann/k8s-controller/internal/controller"
 	//+kubebuilder:scaffold:imports
 )
@@ -46,6 +60,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(myapigroupv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(myapigroupv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -53,25 +68,132 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var otlpEndpoint string
+	var otlpInsecure bool
+	var traceSampleRatio float64
+	var watchNamespaces string
+	var shardSelector string
+	var shardID, shardCount int
+	var configFile string
+	var dryRun bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8082", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&configFile, "config", "",
+		"The path of the controller config file. Its settings override the flags, its log level, "+
+			"defaults and feature gates are reloaded when the file changes.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma separated list of the namespaces the controller watches. All namespaces are watched when empty.")
+	flag.StringVar(&shardSelector, "shard-selector", "",
+		"Label selector of the MyAppResources reconciled by this shard, e.g. shard=a. All of them are reconciled when empty.")
+	flag.IntVar(&shardID, "shard-id", 0, "The shard of this replica, from 0 to --shard-count - 1.")
+	flag.IntVar(&shardCount, "shard-count", 1,
+		"Split the MyAppResources into this many shards by a hash of their namespace and name.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Only report the changes to the children of every MyAppResource in its status, without making them.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "",
+		"The host:port of the OTLP/gRPC collector the reconcile traces are sent to. Tracing is disabled when empty.")
+	flag.BoolVar(&otlpInsecure, "otlp-insecure", false, "Connect to the OTLP collector without TLS.")
+	flag.Float64Var(&traceSampleRatio, "trace-sample-ratio", 1, "The fraction of reconciles that are traced.")
 	opts := zap.Options{
 		Development: true,
 	}
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
+	// Keep the log level adjustable, the config file can change it
+	logLevel, ok := opts.Level.(uberzap.AtomicLevel)
+	if !ok {
+		logLevel = uberzap.NewAtomicLevelAt(zapcore.DebugLevel)
+		opts.Level = logLevel
+	}
+	flagLevel := logLevel.Level()
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	// The config file is reloaded while running, settings that cannot be
+	// changed on the fly need a restart
+	cfg := config.Default()
+	var configStore *config.Store
+	if configFile != "" {
+		loaded, err := config.Load(configFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load config", "path", configFile)
+			os.Exit(1)
+		}
+		cfg = loaded
+		configStore = config.NewStore(configFile, cfg, ctrl.Log.WithName("config"))
+		configStore.OnChange(func(_, c *config.Config) {
+			applyLogLevel(logLevel, flagLevel, c)
+		})
+		setupLog.Info("loaded config", "path", configFile, "version", cfg.Revision())
+	}
+	applyLogLevel(logLevel, flagLevel, cfg)
+
+	// Spans are only recorded with a collector, the global tracer provider
+	// does nothing otherwise
+	shutdownTracing := func() {}
+	if otlpEndpoint != "" {
+		tp, err := setupTracing(context.Background(), otlpEndpoint, otlpInsecure, traceSampleRatio)
+		if err != nil {
+			setupLog.Error(err, "unable to set up tracing")
+			os.Exit(1)
+		}
+		otel.SetTracerProvider(tp)
+		shutdownTracing = func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := tp.Shutdown(ctx); err != nil {
+				setupLog.Error(err, "unable to flush traces")
+			}
+		}
+		setupLog.Info("tracing enabled", "endpoint", otlpEndpoint)
+	}
+
+	// Restrict the cache to the watched namespaces, so that only Roles in
+	// these namespaces are needed instead of a ClusterRole
+	namespaces := parseNamespaces(watchNamespaces)
+	var cacheOpts cache.Options
+	if len(namespaces) > 0 {
+		cacheOpts.DefaultNamespaces = make(map[string]cache.Config, len(namespaces))
+		for _, namespace := range namespaces {
+			cacheOpts.DefaultNamespaces[namespace] = cache.Config{}
+		}
+		setupLog.Info("watching namespaces", "namespaces", namespaces)
+	}
+
+	// The ReplicaSets are only read to roll back the podinfo Deployments, so
+	// only theirs are cached. They carry no shard labels, every shard caches
+	// the podinfo ReplicaSets of the watched namespaces.
+	cacheOpts.ByObject = map[client.Object]cache.ByObject{
+		&appsv1.ReplicaSet{}: {Label: controller.PodinfoSelector()},
+	}
+
+	// Each shard reconciles a disjoint subset of the MyAppResources and
+	// elects its own leader
+	shard, err := parseShard(shardSelector, shardID, shardCount)
+	if err != nil {
+		setupLog.Error(err, "invalid shard")
+		os.Exit(1)
+	}
+	if shard != nil {
+		if shard.Selector != nil {
+			cacheOpts.ByObject[&myapigroupv1alpha1.MyAppResource{}] = cache.ByObject{Label: shard.Selector}
+		}
+		setupLog.Info("reconciling shard", "shard", shard.String())
+	}
+	if dryRun {
+		setupLog.Info("dry-run mode, changes are only reported in the status of each MyAppResource")
+	}
+
+	mgrOpts := ctrl.Options{
 		Scheme:                 scheme,
+		Cache:                  cacheOpts,
 		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "4bd5e203.my.api.group",
+		LeaderElectionID:       shard.LeaderElectionID("4bd5e203.my.api.group"),
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -83,21 +205,47 @@ func main() {
 		// if you are doing or is intended to do any operation such as perform cleanups
 		// after the manager stops then its usage might be unsafe.
 		// LeaderElectionReleaseOnCancel: true,
-	})
+	}
+	// ConfigMaps and Secrets are only watched by their metadata, the data of
+	// the few that are referenced is read from the API server
+	mgrOpts.Client.Cache = &client.CacheOptions{DisableFor: []client.Object{&corev1.ConfigMap{}, &corev1.Secret{}}}
+	applyManagerConfig(&mgrOpts, cfg)
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), mgrOpts)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
 	if err = (&controller.MyAppResourceReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("MyAppResource"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("myappresource-controller"),
+
+		WatchNamespaces: namespaces,
+		Shard:           shard,
+		Config:          configStore,
+		DryRun:          dryRun,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "MyAppResource")
 		os.Exit(1)
 	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&myapigroupv1alpha1.MyAppResource{}).SetupWebhookWithManager(mgr, configStore); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "MyAppResource")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
+	if configStore != nil {
+		if err := mgr.Add(configStore); err != nil {
+			setupLog.Error(err, "unable to watch config", "path", configFile)
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -108,8 +256,41 @@ func main() {
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	err = mgr.Start(ctrl.SetupSignalHandler())
+	shutdownTracing()
+	if err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// parseNamespaces splits the comma separated --watch-namespaces value.
+func parseNamespaces(value string) []string {
+	var namespaces []string
+	for _, namespace := range strings.Split(value, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// parseShard returns the shard set by the --shard-* flags, nil when there is
+// a single shard.
+func parseShard(selector string, id, count int) (*controller.Shard, error) {
+	if count < 1 || id < 0 || id >= count {
+		return nil, fmt.Errorf("--shard-id %d is not within --shard-count %d", id, count)
+	}
+	if selector == "" && count == 1 {
+		return nil, nil
+	}
+	shard := &controller.Shard{ID: id, Count: count}
+	if selector != "" {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("--shard-selector: %w", err)
+		}
+		shard.Selector = parsed
+	}
+	return shard, nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command render prints the objects the controller creates for MyAppResource
+// manifests, without a cluster. It reads the files given as arguments, or
+// stdin, and also runs as a KRM function when stdin holds a ResourceList.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-logr/logr"
+
+	"github.com/sumyann/k8s-controller/internal/config"
+	"github.com/sumyann/k8s-controller/internal/controller"
+	"github.com/sumyann/k8s-controller/internal/render"
+)
+
+func main() {
+	var configFile string
+	flag.StringVar(&configFile, "config", "",
+		"The controller config file whose defaults are used, as the controller would.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [--config=FILE] [MANIFEST...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(configFile, flag.Args(), os.Stdin, os.Stdout); err != nil {
+		if !errors.Is(err, render.ErrInvalid) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(configFile string, files []string, stdin io.Reader, stdout io.Writer) error {
+	cfg := config.Default()
+	if configFile != "" {
+		var err error
+		if cfg, err = config.Load(configFile); err != nil {
+			return err
+		}
+	}
+	r := &controller.MyAppResourceReconciler{
+		Log:    logr.Discard(),
+		Config: config.NewStore(configFile, cfg, logr.Discard()),
+	}
+
+	in := stdin
+	if len(files) > 0 {
+		var buf bytes.Buffer
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			buf.Write(data)
+			buf.WriteString("\n---\n")
+		}
+		in = &buf
+	}
+
+	warnings, err := render.Run(r, in, stdout)
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: MyAppResource %s/%s: %s\n", warning.Namespace, warning.Name, warning.Message)
+	}
+	return err
+}
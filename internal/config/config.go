@@ -0,0 +1,303 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config loads the configuration file of the controller manager.
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// GroupVersion and Kind identify the schema of the configuration file.
+var GroupVersion = schema.GroupVersion{Group: "config.my.api.group.my.api.group", Version: "v1alpha1"}
+
+const Kind = "ControllerConfig"
+
+// Built-in podinfo image, used when the file does not set defaults.podinfo.
+const (
+	DefaultImageRepository = "ghcr.io/stefanprodan/podinfo"
+	DefaultImageTag        = "latest"
+)
+
+// Feature gates of the controller. All of them are enabled by default.
+const (
+	// ConfigChecksum rolls the podinfo pods when the ConfigMaps or Secrets
+	// referenced by the spec change.
+	ConfigChecksum = "ConfigChecksum"
+	// EnvPruning removes env vars dropped from spec.env that another field
+	// manager still holds.
+	EnvPruning = "EnvPruning"
+)
+
+var defaultFeatureGates = map[string]bool{
+	ConfigChecksum: true,
+	EnvPruning:     true,
+}
+
+// Config is the configuration file of the controller manager, usually mounted
+// from a ConfigMap. Fields that are not set keep the value of the matching
+// command line flag, or the built-in default.
+type Config struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// Version is a free-form revision of the file, logged when it is applied.
+	Version string `json:"version,omitempty"`
+
+	// LogLevel is the minimum level of the log, e.g. debug, info or error.
+	// It is reloaded without a restart.
+	LogLevel string `json:"logLevel,omitempty"`
+
+	Metrics        Metrics        `json:"metrics,omitempty"`
+	Health         Health         `json:"health,omitempty"`
+	LeaderElection LeaderElection `json:"leaderElection,omitempty"`
+	Controller     Controller     `json:"controller,omitempty"`
+
+	// Defaults are used for the MyAppResources that leave the matching spec
+	// fields empty. They are reloaded without a restart.
+	Defaults Defaults `json:"defaults,omitempty"`
+
+	// FeatureGates turns features of the controller on or off. They are
+	// reloaded without a restart.
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+
+	// checksum identifies the content of the file when Version is not set.
+	checksum string
+}
+
+// Metrics configures the metrics endpoint.
+type Metrics struct {
+	BindAddress string `json:"bindAddress,omitempty"`
+}
+
+// Health configures the health probe endpoint.
+type Health struct {
+	ProbeBindAddress string `json:"probeBindAddress,omitempty"`
+}
+
+// LeaderElection configures the leader election of the manager.
+type LeaderElection struct {
+	LeaderElect   *bool            `json:"leaderElect,omitempty"`
+	LeaseDuration *metav1.Duration `json:"leaseDuration,omitempty"`
+	RenewDeadline *metav1.Duration `json:"renewDeadline,omitempty"`
+	RetryPeriod   *metav1.Duration `json:"retryPeriod,omitempty"`
+}
+
+// Controller configures the MyAppResource controller.
+type Controller struct {
+	// MaxConcurrentReconciles is the number of MyAppResources reconciled in
+	// parallel.
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+
+	// SyncPeriod is the resync period of the cache, after which every
+	// MyAppResource is reconciled again.
+	SyncPeriod *metav1.Duration `json:"syncPeriod,omitempty"`
+}
+
+// Defaults of the MyAppResource spec.
+type Defaults struct {
+	Podinfo PodinfoDefaults `json:"podinfo,omitempty"`
+	Redis   RedisDefaults   `json:"redis,omitempty"`
+}
+
+// PodinfoDefaults are used for the podinfo Deployment.
+type PodinfoDefaults struct {
+	// Repository and Tag are used when spec.image leaves them empty.
+	Repository string `json:"repository,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+
+	// Resources are used when spec.resources sets no requests or limits.
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// RedisDefaults are used for the managed Redis.
+type RedisDefaults struct {
+	// Image of Redis and of the snapshot Job.
+	Image string `json:"image,omitempty"`
+
+	// Resources are used when spec.redis.resources sets no requests or limits.
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// Default returns the configuration used without a configuration file.
+func Default() *Config {
+	c := &Config{}
+	c.SetDefaults()
+	return c
+}
+
+// SetDefaults fills in the defaults of the fields that have a built-in value.
+func (c *Config) SetDefaults() {
+	if c.Defaults.Podinfo.Repository == "" {
+		c.Defaults.Podinfo.Repository = DefaultImageRepository
+	}
+	if c.Defaults.Podinfo.Tag == "" {
+		c.Defaults.Podinfo.Tag = DefaultImageTag
+	}
+	if isEmpty(c.Defaults.Podinfo.Resources) {
+		c.Defaults.Podinfo.Resources = corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("32Mi")},
+			Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+		}
+	}
+	if c.Defaults.Redis.Image == "" {
+		c.Defaults.Redis.Image = "redis:latest"
+	}
+	if isEmpty(c.Defaults.Redis.Resources) {
+		c.Defaults.Redis.Resources = corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m"), corev1.ResourceMemory: resource.MustParse("32Mi")},
+			Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+		}
+	}
+	if c.Controller.MaxConcurrentReconciles == 0 {
+		c.Controller.MaxConcurrentReconciles = 1
+	}
+}
+
+// isEmpty reports whether res sets no requests or limits.
+func isEmpty(res corev1.ResourceRequirements) bool {
+	return len(res.Requests) == 0 && len(res.Limits) == 0
+}
+
+// Load reads, defaults and validates the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse decodes and validates a configuration file. Unknown fields are
+// rejected, so typos do not go unnoticed.
+func Parse(data []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, err
+	}
+	if c.APIVersion != GroupVersion.String() || c.Kind != Kind {
+		return nil, fmt.Errorf("unsupported config %s %s, expected %s %s", c.APIVersion, c.Kind, GroupVersion, Kind)
+	}
+	c.SetDefaults()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	c.checksum = hex.EncodeToString(sum[:])[:12]
+	return c, nil
+}
+
+// Validate checks the values of the configuration.
+func (c *Config) Validate() error {
+	var errs []string
+	if c.LogLevel != "" {
+		if _, err := c.Level(); err != nil {
+			errs = append(errs, fmt.Sprintf("logLevel: %v", err))
+		}
+	}
+	if c.Controller.MaxConcurrentReconciles < 1 {
+		errs = append(errs, "controller.maxConcurrentReconciles must be at least 1")
+	}
+	if d := c.Controller.SyncPeriod; d != nil && d.Duration <= 0 {
+		errs = append(errs, "controller.syncPeriod must be positive")
+	}
+	for _, gate := range sortedKeys(c.FeatureGates) {
+		if _, ok := defaultFeatureGates[gate]; !ok {
+			errs = append(errs, fmt.Sprintf("featureGates: unknown feature gate %q", gate))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Level returns LogLevel as a zap level.
+func (c *Config) Level() (zapcore.Level, error) {
+	return zapcore.ParseLevel(c.LogLevel)
+}
+
+// Enabled reports whether the feature gate is on.
+func (c *Config) Enabled(gate string) bool {
+	if enabled, ok := c.FeatureGates[gate]; ok {
+		return enabled
+	}
+	return defaultFeatureGates[gate]
+}
+
+// Revision identifies the applied configuration in the logs: the version set
+// in the file, or a checksum of its content.
+func (c *Config) Revision() string {
+	if c.Version != "" {
+		return c.Version
+	}
+	return c.checksum
+}
+
+// RestartRequired lists the fields that differ between c and other and only
+// take effect when the manager is restarted.
+func (c *Config) RestartRequired(other *Config) []string {
+	var fields []string
+	if c.Metrics != other.Metrics {
+		fields = append(fields, "metrics")
+	}
+	if c.Health != other.Health {
+		fields = append(fields, "health")
+	}
+	if !equalLeaderElection(c.LeaderElection, other.LeaderElection) {
+		fields = append(fields, "leaderElection")
+	}
+	if c.Controller.MaxConcurrentReconciles != other.Controller.MaxConcurrentReconciles ||
+		!equalDuration(c.Controller.SyncPeriod, other.Controller.SyncPeriod) {
+		fields = append(fields, "controller")
+	}
+	return fields
+}
+
+func equalLeaderElection(a, b LeaderElection) bool {
+	leaderElect := (a.LeaderElect == nil) == (b.LeaderElect == nil) && (a.LeaderElect == nil || *a.LeaderElect == *b.LeaderElect)
+	return leaderElect &&
+		equalDuration(a.LeaseDuration, b.LeaseDuration) &&
+		equalDuration(a.RenewDeadline, b.RenewDeadline) &&
+		equalDuration(a.RetryPeriod, b.RetryPeriod)
+}
+
+func equalDuration(a, b *metav1.Duration) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Duration == b.Duration
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
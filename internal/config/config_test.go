@@ -0,0 +1,100 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const testConfig = `
+apiVersion: config.my.api.group.my.api.group/v1alpha1
+kind: ControllerConfig
+version: "7"
+logLevel: info
+metrics:
+  bindAddress: ":9090"
+leaderElection:
+  leaderElect: true
+  leaseDuration: 30s
+controller:
+  maxConcurrentReconciles: 4
+  syncPeriod: 1h
+defaults:
+  podinfo:
+    repository: registry.example.com/podinfo
+    resources:
+      requests:
+        cpu: 50m
+  redis:
+    image: redis:7
+featureGates:
+  EnvPruning: false
+`
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	require.NoError(t, err)
+
+	require.Equal(t, "7", c.Revision())
+	level, err := c.Level()
+	require.NoError(t, err)
+	require.Equal(t, zapcore.InfoLevel, level)
+	require.Equal(t, ":9090", c.Metrics.BindAddress)
+	require.True(t, *c.LeaderElection.LeaderElect)
+	require.Equal(t, 30*time.Second, c.LeaderElection.LeaseDuration.Duration)
+	require.Equal(t, 4, c.Controller.MaxConcurrentReconciles)
+	require.Equal(t, time.Hour, c.Controller.SyncPeriod.Duration)
+
+	// Unset defaults keep their built-in value
+	require.Equal(t, "registry.example.com/podinfo", c.Defaults.Podinfo.Repository)
+	require.Equal(t, "latest", c.Defaults.Podinfo.Tag)
+	require.Equal(t, resource.MustParse("50m"), c.Defaults.Podinfo.Resources.Requests[corev1.ResourceCPU])
+	require.Nil(t, c.Defaults.Podinfo.Resources.Limits)
+	require.Equal(t, "redis:7", c.Defaults.Redis.Image)
+	require.Equal(t, resource.MustParse("128Mi"), c.Defaults.Redis.Resources.Limits[corev1.ResourceMemory])
+
+	require.False(t, c.Enabled(EnvPruning))
+	require.True(t, c.Enabled(ConfigChecksum))
+}
+
+func TestParseInvalid(t *testing.T) {
+	header := "apiVersion: config.my.api.group.my.api.group/v1alpha1\nkind: ControllerConfig\n"
+	for name, data := range map[string]string{
+		"wrong kind":      "apiVersion: config.my.api.group.my.api.group/v1alpha1\nkind: Other\n",
+		"unknown field":   header + "metric:\n  bindAddress: \":9090\"\n",
+		"unknown gate":    header + "featureGates:\n  Teleport: true\n",
+		"bad log level":   header + "logLevel: loud\n",
+		"bad concurrency": header + "controller:\n  maxConcurrentReconciles: -1\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(data))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestRevisionWithoutVersion(t *testing.T) {
+	a, err := Parse([]byte("apiVersion: config.my.api.group.my.api.group/v1alpha1\nkind: ControllerConfig\n"))
+	require.NoError(t, err)
+	b, err := Parse([]byte("apiVersion: config.my.api.group.my.api.group/v1alpha1\nkind: ControllerConfig\nlogLevel: debug\n"))
+	require.NoError(t, err)
+	require.Len(t, a.Revision(), 12)
+	require.NotEqual(t, a.Revision(), b.Revision())
+}
+
+func TestRestartRequired(t *testing.T) {
+	a, err := Parse([]byte(testConfig))
+	require.NoError(t, err)
+	b := *a
+	b.LogLevel = "debug"
+	b.FeatureGates = map[string]bool{}
+	require.Empty(t, a.RestartRequired(&b))
+
+	b.Metrics.BindAddress = ":9091"
+	b.Controller.MaxConcurrentReconciles = 2
+	require.Equal(t, []string{"metrics", "controller"}, a.RestartRequired(&b))
+}
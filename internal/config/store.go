@@ -0,0 +1,126 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+)
+
+// Store holds the current configuration and reloads it when the file changes.
+// A nil Store serves the default configuration.
+type Store struct {
+	path string
+	log  logr.Logger
+
+	mu       sync.RWMutex
+	current  *Config
+	onChange []func(old, new *Config)
+}
+
+// NewStore returns a Store serving c, loaded from path.
+func NewStore(path string, c *Config, log logr.Logger) *Store {
+	return &Store{path: path, current: c, log: log}
+}
+
+// Get returns the current configuration. It must not be modified.
+func (s *Store) Get() *Config {
+	if s == nil {
+		return Default()
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// OnChange registers fn to be called after a new configuration is applied.
+func (s *Store) OnChange(fn func(old, new *Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = append(s.onChange, fn)
+}
+
+// Reload reads the file again and applies it if it changed. An invalid file
+// is logged and the current configuration is kept.
+func (s *Store) Reload() {
+	c, err := Load(s.path)
+	if err != nil {
+		s.log.Error(err, "Failed to reload config, keeping the current one", "path", s.path)
+		return
+	}
+
+	s.mu.Lock()
+	old := s.current
+	if old.checksum == c.checksum {
+		s.mu.Unlock()
+		return
+	}
+	s.current = c
+	callbacks := s.onChange
+	s.mu.Unlock()
+
+	s.log.Info("Applied config", "version", c.Revision(), "previous", old.Revision())
+	if fields := old.RestartRequired(c); len(fields) > 0 {
+		s.log.Info("Config changes need a restart to take effect", "fields", fields)
+	}
+	for _, fn := range callbacks {
+		fn(old, c)
+	}
+}
+
+// Start watches the directory of the file and reloads it on every change
+// until ctx is done. The directory is watched rather than the file, since a
+// mounted ConfigMap is updated by swapping a symlink.
+func (s *Store) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(s.path)); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+				s.Reload()
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			s.log.Error(err, "Failed to watch config", "path", s.path)
+		}
+	}
+}
+
+// NeedLeaderElection makes every replica reload the configuration, not only
+// the leader.
+func (s *Store) NeedLeaderElection() bool {
+	return false
+}
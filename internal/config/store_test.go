@@ -0,0 +1,43 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testConfig), 0o600))
+	c, err := Load(path)
+	require.NoError(t, err)
+
+	s := NewStore(path, c, logr.Discard())
+	var applied []string
+	s.OnChange(func(old, new *Config) { applied = append(applied, old.Revision()+"->"+new.Revision()) })
+
+	// An unchanged file is not applied again
+	s.Reload()
+	require.Empty(t, applied)
+
+	updated := strings.Replace(testConfig, `version: "7"`, `version: "8"`, 1)
+	require.NoError(t, os.WriteFile(path, []byte(updated), 0o600))
+	s.Reload()
+	require.Equal(t, []string{"7->8"}, applied)
+	require.Equal(t, "8", s.Get().Revision())
+
+	// An invalid file keeps the current config
+	require.NoError(t, os.WriteFile(path, []byte("kind: Other\n"), 0o600))
+	s.Reload()
+	require.Equal(t, "8", s.Get().Revision())
+	require.Len(t, applied, 1)
+}
+
+func TestNilStore(t *testing.T) {
+	var s *Store
+	require.Equal(t, Default(), s.Get())
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	metav1ac "k8s.io/client-go/applyconfigurations/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+// fieldManager is the server-side apply field manager of the controller. Only
+// the fields present in the applied configurations are owned by it, everything
+// else on the children is left to other managers.
+const fieldManager = "myappresource-controller"
+
+// applyPatch sends an apply configuration as a server-side apply patch, so the
+// object passed to Patch receives the live object from the API server.
+type applyPatch struct {
+	applyConfiguration interface{}
+}
+
+func (p applyPatch) Type() types.PatchType {
+	return types.ApplyPatchType
+}
+
+func (p applyPatch) Data(client.Object) ([]byte, error) {
+	return json.Marshal(p.applyConfiguration)
+}
+
+// applyResult is the effect of an apply on the live object.
+type applyResult int
+
+const (
+	applyUnchanged applyResult = iota
+	applyCreated
+	applyUpdated
+)
+
+// apply server-side applies the configuration ac under fieldManager and stores
+// the resulting object in obj, which must carry the name and namespace of ac.
+// Conflicting fields are taken over, we only send the fields we own.
+func (r *MyAppResourceReconciler) apply(ctx context.Context, obj client.Object, ac interface{}) (applyResult, error) {
+	before := obj.DeepCopyObject().(client.Object)
+	err := r.Get(ctx, client.ObjectKeyFromObject(obj), before)
+	if err != nil && !errors.IsNotFound(err) {
+		return applyUnchanged, err
+	}
+	existed := err == nil
+
+	if err := r.Patch(ctx, obj, applyPatch{applyConfiguration: ac}, client.FieldOwner(fieldManager), client.ForceOwnership); err != nil {
+		return applyUnchanged, err
+	}
+
+	switch {
+	case !existed:
+		return applyCreated, nil
+	case changedByApply(before, obj):
+		return applyUpdated, nil
+	default:
+		return applyUnchanged, nil
+	}
+}
+
+// changedByApply reports whether the desired state of the object changed
+// between before and after. Status and bookkeeping metadata are ignored.
+func changedByApply(before, after client.Object) bool {
+	return !equality.Semantic.DeepEqual(desiredState(before), desiredState(after))
+}
+
+// desiredState returns obj as a map without status and bookkeeping metadata.
+func desiredState(obj client.Object) map[string]interface{} {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil
+	}
+	delete(u, "status")
+	if metadata, ok := u["metadata"].(map[string]interface{}); ok {
+		for _, field := range []string{"resourceVersion", "generation", "managedFields", "creationTimestamp", "uid"} {
+			delete(metadata, field)
+		}
+	}
+	return u
+}
+
+// ownerReference returns the controller reference set on every child of m.
+func ownerReference(m *appv1alpha1.MyAppResource) *metav1ac.OwnerReferenceApplyConfiguration {
+	return metav1ac.OwnerReference().
+		WithAPIVersion(appv1alpha1.GroupVersion.String()).
+		WithKind("MyAppResource").
+		WithName(m.Name).
+		WithUID(m.UID).
+		WithController(true).
+		WithBlockOwnerDeletion(true)
+}
+
+// resourceRequirementsApplyConfiguration converts container resources, leaving
+// out empty lists so that no ownership is claimed on them.
+func resourceRequirementsApplyConfiguration(res corev1.ResourceRequirements) *corev1ac.ResourceRequirementsApplyConfiguration {
+	ac := corev1ac.ResourceRequirements()
+	if len(res.Requests) > 0 {
+		ac.WithRequests(res.Requests)
+	}
+	if len(res.Limits) > 0 {
+		ac.WithLimits(res.Limits)
+	}
+	return ac
+}
+
+// envVarApplyConfigurations converts env vars, including their valueFrom source.
+func envVarApplyConfigurations(envVars []corev1.EnvVar) []*corev1ac.EnvVarApplyConfiguration {
+	out := make([]*corev1ac.EnvVarApplyConfiguration, 0, len(envVars))
+	for _, envVar := range envVars {
+		ac := corev1ac.EnvVar().WithName(envVar.Name)
+		if envVar.Value != "" {
+			ac.WithValue(envVar.Value)
+		}
+		if envVar.ValueFrom != nil {
+			ac.WithValueFrom(envVarSourceApplyConfiguration(envVar.ValueFrom))
+		}
+		out = append(out, ac)
+	}
+	return out
+}
+
+// envFromApplyConfigurations converts envFrom sources.
+func envFromApplyConfigurations(sources []corev1.EnvFromSource) []*corev1ac.EnvFromSourceApplyConfiguration {
+	out := make([]*corev1ac.EnvFromSourceApplyConfiguration, 0, len(sources))
+	for _, source := range sources {
+		ac := corev1ac.EnvFromSource()
+		if source.Prefix != "" {
+			ac.WithPrefix(source.Prefix)
+		}
+		if ref := source.ConfigMapRef; ref != nil {
+			configMap := corev1ac.ConfigMapEnvSource().WithName(ref.Name)
+			if ref.Optional != nil {
+				configMap.WithOptional(*ref.Optional)
+			}
+			ac.WithConfigMapRef(configMap)
+		}
+		if ref := source.SecretRef; ref != nil {
+			secret := corev1ac.SecretEnvSource().WithName(ref.Name)
+			if ref.Optional != nil {
+				secret.WithOptional(*ref.Optional)
+			}
+			ac.WithSecretRef(secret)
+		}
+		out = append(out, ac)
+	}
+	return out
+}
+
+func envVarSourceApplyConfiguration(src *corev1.EnvVarSource) *corev1ac.EnvVarSourceApplyConfiguration {
+	ac := corev1ac.EnvVarSource()
+	if ref := src.FieldRef; ref != nil {
+		selector := corev1ac.ObjectFieldSelector().WithFieldPath(ref.FieldPath)
+		if ref.APIVersion != "" {
+			selector.WithAPIVersion(ref.APIVersion)
+		}
+		ac.WithFieldRef(selector)
+	}
+	if ref := src.ResourceFieldRef; ref != nil {
+		selector := corev1ac.ResourceFieldSelector().WithResource(ref.Resource)
+		if ref.ContainerName != "" {
+			selector.WithContainerName(ref.ContainerName)
+		}
+		if !ref.Divisor.IsZero() {
+			selector.WithDivisor(ref.Divisor)
+		}
+		ac.WithResourceFieldRef(selector)
+	}
+	if ref := src.ConfigMapKeyRef; ref != nil {
+		selector := corev1ac.ConfigMapKeySelector().WithName(ref.Name).WithKey(ref.Key)
+		if ref.Optional != nil {
+			selector.WithOptional(*ref.Optional)
+		}
+		ac.WithConfigMapKeyRef(selector)
+	}
+	if ref := src.SecretKeyRef; ref != nil {
+		selector := corev1ac.SecretKeySelector().WithName(ref.Name).WithKey(ref.Key)
+		if ref.Optional != nil {
+			selector.WithOptional(*ref.Optional)
+		}
+		ac.WithSecretKeyRef(selector)
+	}
+	return ac
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+// configChecksumAnnotation is stamped on the podinfo pod template with a
+// checksum of the ConfigMaps and Secrets referenced by the spec, so that a
+// change of their data rolls the pods.
+const configChecksumAnnotation = "my.api.group.my.api.group/config-checksum"
+
+// Field indexes of MyAppResource over the names of the ConfigMaps and Secrets
+// it references, used to map changes of those objects back to the CRs.
+const (
+	configMapIndexKey = ".spec.configMapRefs"
+	secretIndexKey    = ".spec.secretRefs"
+)
+
+// referencedConfigMaps returns the sorted names of the ConfigMaps referenced by
+// spec.env and spec.envFrom.
+func referencedConfigMaps(m *appv1alpha1.MyAppResource) []string {
+	names := sets.New[string]()
+	for _, envVar := range m.Spec.Env {
+		if envVar.ValueFrom != nil && envVar.ValueFrom.ConfigMapKeyRef != nil {
+			names.Insert(envVar.ValueFrom.ConfigMapKeyRef.Name)
+		}
+	}
+	for _, envFrom := range m.Spec.EnvFrom {
+		if envFrom.ConfigMapRef != nil {
+			names.Insert(envFrom.ConfigMapRef.Name)
+		}
+	}
+	return sets.List(names)
+}
+
+// referencedSecrets returns the sorted names of the Secrets referenced by
+// spec.env and spec.envFrom.
+func referencedSecrets(m *appv1alpha1.MyAppResource) []string {
+	names := sets.New[string]()
+	for _, envVar := range m.Spec.Env {
+		if envVar.ValueFrom != nil && envVar.ValueFrom.SecretKeyRef != nil {
+			names.Insert(envVar.ValueFrom.SecretKeyRef.Name)
+		}
+	}
+	for _, envFrom := range m.Spec.EnvFrom {
+		if envFrom.SecretRef != nil {
+			names.Insert(envFrom.SecretRef.Name)
+		}
+	}
+	return sets.List(names)
+}
+
+// configChecksum returns a checksum over the data of the ConfigMaps and Secrets
+// referenced by m, or an empty string when there are none. Missing objects are
+// part of the checksum, so the pods are rolled once they are created.
+func (r *MyAppResourceReconciler) configChecksum(ctx context.Context, m *appv1alpha1.MyAppResource) (string, error) {
+	configMaps := referencedConfigMaps(m)
+	secrets := referencedSecrets(m)
+	if len(configMaps) == 0 && len(secrets) == 0 {
+		return "", nil
+	}
+
+	h := sha256.New()
+	for _, name := range configMaps {
+		configMap := &corev1.ConfigMap{}
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: m.Namespace}, configMap)
+		if errors.IsNotFound(err) {
+			fmt.Fprintf(h, "configmap/%s missing\n", name)
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "configmap/%s\n", name)
+		writeData(h, configMap.Data)
+		binaryData := make(map[string]string, len(configMap.BinaryData))
+		for key, value := range configMap.BinaryData {
+			binaryData[key] = string(value)
+		}
+		writeData(h, binaryData)
+	}
+	for _, name := range secrets {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: m.Namespace}, secret)
+		if errors.IsNotFound(err) {
+			fmt.Fprintf(h, "secret/%s missing\n", name)
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "secret/%s\n", name)
+		data := make(map[string]string, len(secret.Data))
+		for key, value := range secret.Data {
+			data[key] = string(value)
+		}
+		writeData(h, data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeData writes data to h in key order.
+func writeData(h hash.Hash, data map[string]string) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s\x00%s\x00", key, data[key])
+	}
+}
+
+// requestsForConfigMap maps a ConfigMap to the MyAppResources referencing it.
+func (r *MyAppResourceReconciler) requestsForConfigMap(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.requestsForIndex(ctx, configMapIndexKey, obj)
+}
+
+// requestsForSecret maps a Secret to the MyAppResources referencing it.
+func (r *MyAppResourceReconciler) requestsForSecret(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.requestsForIndex(ctx, secretIndexKey, obj)
+}
+
+func (r *MyAppResourceReconciler) requestsForIndex(ctx context.Context, indexKey string, obj client.Object) []reconcile.Request {
+	list := &appv1alpha1.MyAppResourceList{}
+	if err := r.List(ctx, list, client.InNamespace(obj.GetNamespace()), client.MatchingFields{indexKey: obj.GetName()}); err != nil {
+		r.logger(ctx).Error(err, "Failed to list MyAppResources", "Index", indexKey, "Namespace", obj.GetNamespace(), "Name", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for _, item := range list.Items {
+		if !r.Shard.Contains(client.ObjectKeyFromObject(&item), item.Labels) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: item.Name, Namespace: item.Namespace},
+		})
+	}
+	return requests
+}
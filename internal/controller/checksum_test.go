@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func myAppResourceWithRefs() *appv1alpha1.MyAppResource {
+	m := &appv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default"}}
+	m.Spec.Env = []corev1.EnvVar{{
+		Name: "PASSWORD",
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "podinfo-secret"}, Key: "password"},
+		},
+	}}
+	m.Spec.EnvFrom = []corev1.EnvFromSource{
+		{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "podinfo-config"}}},
+		{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "podinfo-secret"}}},
+	}
+	return m
+}
+
+func TestReferencedObjects(t *testing.T) {
+	m := myAppResourceWithRefs()
+	require.Equal(t, []string{"podinfo-config"}, referencedConfigMaps(m))
+	require.Equal(t, []string{"podinfo-secret"}, referencedSecrets(m))
+	require.Empty(t, referencedConfigMaps(&appv1alpha1.MyAppResource{}))
+}
+
+func TestConfigChecksum(t *testing.T) {
+	ctx := context.Background()
+	m := myAppResourceWithRefs()
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "podinfo-config", Namespace: "default"},
+		Data:       map[string]string{"PODINFO_LEVEL": "info"},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(configMap).Build()
+	r := &MyAppResourceReconciler{Client: c}
+
+	empty, err := r.configChecksum(ctx, &appv1alpha1.MyAppResource{})
+	require.NoError(t, err)
+	require.Empty(t, empty)
+
+	missingSecret, err := r.configChecksum(ctx, m)
+	require.NoError(t, err)
+	require.NotEmpty(t, missingSecret)
+
+	require.NoError(t, c.Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "podinfo-secret", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("s3cr3t")},
+	}))
+	withSecret, err := r.configChecksum(ctx, m)
+	require.NoError(t, err)
+	require.NotEqual(t, missingSecret, withSecret)
+
+	again, err := r.configChecksum(ctx, m)
+	require.NoError(t, err)
+	require.Equal(t, withSecret, again)
+
+	configMap.Data["PODINFO_LEVEL"] = "debug"
+	require.NoError(t, c.Update(ctx, configMap))
+	changed, err := r.configChecksum(ctx, m)
+	require.NoError(t, err)
+	require.NotEqual(t, withSecret, changed)
+}
+
+func TestRequestsForConfigMap(t *testing.T) {
+	m := myAppResourceWithRefs()
+	other := &appv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: "other-app", Namespace: "default"}}
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(m, other).
+		WithIndex(&appv1alpha1.MyAppResource{}, configMapIndexKey, func(obj client.Object) []string {
+			return referencedConfigMaps(obj.(*appv1alpha1.MyAppResource))
+		}).
+		Build()
+	r := &MyAppResourceReconciler{Client: c, Log: ctrl.Log.WithName("test")}
+
+	requests := r.requestsForConfigMap(context.Background(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "podinfo-config", Namespace: "default"},
+	})
+	require.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "example-app", Namespace: "default"}}}, requests)
+}
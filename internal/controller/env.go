@@ -0,0 +1,134 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// managedEnvAnnotation lists the env vars the controller sets on the podinfo
+// container. Server-side apply only prunes entries no other manager owns, this
+// annotation also covers entries co-owned by the Update calls of earlier
+// controller versions.
+const managedEnvAnnotation = "my.api.group.my.api.group/managed-env"
+
+// managedEnvNames returns the env var names the controller set on the live
+// podinfo Deployment d. Deployments without the annotation were written by
+// earlier versions, which replaced the whole env, so all of it is managed.
+func managedEnvNames(d *appsv1.Deployment) []string {
+	if value, ok := d.Annotations[managedEnvAnnotation]; ok {
+		if value == "" {
+			return nil
+		}
+		return strings.Split(value, ",")
+	}
+
+	container := podinfoContainer(d)
+	if container == nil {
+		return nil
+	}
+	names := make([]string, 0, len(container.Env))
+	for _, envVar := range container.Env {
+		names = append(names, envVar.Name)
+	}
+	return names
+}
+
+// envVarNames returns the value of managedEnvAnnotation for envVars.
+func envVarNames(envVars []corev1.EnvVar) string {
+	names := make([]string, 0, len(envVars))
+	for _, envVar := range envVars {
+		names = append(names, envVar.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// keepStaleEnvVars adds the stale env vars of the live Deployment to the
+// managedEnvAnnotation of deployment, so they are still known as managed when
+// pruning them fails and the prune is retried. They drop out of the annotation
+// once they are removed from the container.
+func keepStaleEnvVars(deployment *appsv1ac.DeploymentApplyConfiguration, live *appsv1.Deployment, managed []string, desired []corev1.EnvVar) {
+	stale := staleEnvVars(live, managed, desired)
+	if len(stale) == 0 {
+		return
+	}
+	value := strings.Join(stale, ",")
+	if names := envVarNames(desired); names != "" {
+		value = names + "," + value
+	}
+	deployment.WithAnnotations(map[string]string{managedEnvAnnotation: value})
+}
+
+// staleEnvVars returns the managed env vars that are still set on the podinfo
+// container of d although they are no longer desired.
+func staleEnvVars(d *appsv1.Deployment, managed []string, desired []corev1.EnvVar) []string {
+	container := podinfoContainer(d)
+	if container == nil {
+		return nil
+	}
+
+	var stale []string
+	for _, name := range managed {
+		if hasEnvVar(container.Env, name) && !hasEnvVar(desired, name) {
+			stale = append(stale, name)
+		}
+	}
+	return stale
+}
+
+// pruneEnvVars removes the named env vars from the podinfo container of d with
+// a strategic merge patch, which drops them whoever owns them.
+func (r *MyAppResourceReconciler) pruneEnvVars(ctx context.Context, d *appsv1.Deployment, names []string) error {
+	env := make([]map[string]string, 0, len(names))
+	for _, name := range names {
+		env = append(env, map[string]string{"name": name, "$patch": "delete"})
+	}
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "podinfo", "env": env},
+					},
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	return r.Patch(ctx, d, client.RawPatch(types.StrategicMergePatchType, data), client.FieldOwner(fieldManager))
+}
+
+// podinfoContainer returns the podinfo container of d, or nil.
+func podinfoContainer(d *appsv1.Deployment) *corev1.Container {
+	for i := range d.Spec.Template.Spec.Containers {
+		if d.Spec.Template.Spec.Containers[i].Name == "podinfo" {
+			return &d.Spec.Template.Spec.Containers[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func deploymentWithEnv(annotations map[string]string, envVars ...corev1.EnvVar) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "podinfo", Env: envVars}},
+				},
+			},
+		},
+	}
+}
+
+func TestManagedEnvNames(t *testing.T) {
+	legacy := deploymentWithEnv(nil, corev1.EnvVar{Name: "FOO"}, corev1.EnvVar{Name: "BAR"})
+	require.Equal(t, []string{"FOO", "BAR"}, managedEnvNames(legacy))
+
+	annotated := deploymentWithEnv(map[string]string{managedEnvAnnotation: "FOO"}, corev1.EnvVar{Name: "FOO"}, corev1.EnvVar{Name: "SIDECAR"})
+	require.Equal(t, []string{"FOO"}, managedEnvNames(annotated))
+
+	require.Nil(t, managedEnvNames(deploymentWithEnv(map[string]string{managedEnvAnnotation: ""})))
+}
+
+func TestStaleEnvVars(t *testing.T) {
+	live := deploymentWithEnv(nil,
+		corev1.EnvVar{Name: "KEEP", Value: "1"},
+		corev1.EnvVar{Name: "REMOVED", Value: "2"},
+		corev1.EnvVar{Name: "HUMAN", Value: "3"},
+	)
+	desired := []corev1.EnvVar{{Name: "KEEP", Value: "1"}}
+
+	require.Equal(t, []string{"REMOVED"}, staleEnvVars(live, []string{"KEEP", "REMOVED", "GONE"}, desired))
+	require.Empty(t, staleEnvVars(live, nil, desired))
+}
+
+func TestReconcileRetriesFailedEnvPrune(t *testing.T) {
+	ctx := context.Background()
+	m := &appv1alpha1.MyAppResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default", UID: "uid", Finalizers: []string{finalizerName}},
+		Spec:       appv1alpha1.MyAppResourceSpec{ReplicaCount: 1},
+	}
+	// FOO was removed from spec.env
+	deployment := deploymentWithEnv(map[string]string{managedEnvAnnotation: "FOO,PODINFO_UI_COLOR,PODINFO_UI_MESSAGE"}, corev1.EnvVar{Name: "FOO", Value: "bar"})
+	deployment.Name, deployment.Namespace = "example-app-podinfo", "default"
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "example-app-podinfo", Namespace: "default"}}
+
+	failPrune := true
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(m, deployment, service).WithStatusSubresource(m).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				if failPrune && patch.Type() == types.StrategicMergePatchType {
+					return errors.New("connection refused")
+				}
+				return c.Patch(ctx, obj, patch, opts...)
+			},
+		}).Build()
+	r := &MyAppResourceReconciler{Client: c, Log: ctrl.Log.WithName("test"), Recorder: record.NewFakeRecorder(10)}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: m.Name, Namespace: m.Namespace}}
+	t.Cleanup(func() { deleteMetrics(req.NamespacedName) })
+	getDeployment := func() *appsv1.Deployment {
+		d := &appsv1.Deployment{}
+		require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "example-app-podinfo", Namespace: "default"}, d))
+		return d
+	}
+
+	// The failed prune keeps FOO recorded as managed
+	_, err := r.Reconcile(ctx, req)
+	require.Error(t, err)
+	d := getDeployment()
+	require.Equal(t, []string{"FOO"}, staleEnvVars(d, managedEnvNames(d), podinfoEnvVars(m)))
+
+	failPrune = false
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.False(t, hasEnvVar(podinfoContainer(getDeployment()).Env, "FOO"))
+
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, "PODINFO_UI_COLOR,PODINFO_UI_MESSAGE", getDeployment().Annotations[managedEnvAnnotation])
+}
+
+func TestPodinfoEnvVarsOrder(t *testing.T) {
+	m := &appv1alpha1.MyAppResource{}
+	m.Spec.UI = appv1alpha1.UI{Color: "#34577c", Message: "Hello"}
+	m.Spec.Env = []corev1.EnvVar{
+		{Name: "B", Value: "1"},
+		{Name: "PODINFO_UI_COLOR", Value: "#ffffff"},
+		{Name: "A", Value: "2"},
+		{Name: "B", Value: "3"},
+	}
+
+	envVars := podinfoEnvVars(m)
+	require.Equal(t, []corev1.EnvVar{
+		{Name: "B", Value: "3"},
+		{Name: "PODINFO_UI_COLOR", Value: "#34577c"},
+		{Name: "A", Value: "2"},
+		{Name: "PODINFO_UI_MESSAGE", Value: "Hello"},
+	}, envVars)
+	require.Equal(t, "B,PODINFO_UI_COLOR,A,PODINFO_UI_MESSAGE", envVarNames(envVars))
+	require.Equal(t, envVars, podinfoEnvVars(m))
+}
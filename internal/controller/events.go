@@ -0,0 +1,66 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+// Reasons of the Events recorded on a MyAppResource. Alerting rules match on
+// them, so they must not change. Failures use the reason of the Degraded
+// condition, such as RedisFailed or ApplyFailed.
+const (
+	reasonCreated       = "Created"
+	reasonUpdated       = "Updated"
+	reasonDriftReverted = "DriftReverted"
+	reasonConfigChanged = "ConfigChanged"
+	reasonScaled        = "Scaled"
+	reasonRemoved       = "Removed"
+	reasonEnvPruned     = "EnvPruned"
+	reasonShardChanged  = "ShardChanged"
+	reasonDryRun        = "DryRun"
+	reasonSynced        = "Synced"
+	reasonRestarted     = "Restarted"
+	reasonRolledBack    = "RolledBack"
+
+	reasonDeleted           = "Deleted"
+	reasonOrphaned          = "Orphaned"
+	reasonOrphanFailed      = "OrphanFailed"
+	reasonSnapshotStarted   = "SnapshotStarted"
+	reasonSnapshotCompleted = "SnapshotCompleted"
+	reasonSnapshotFailed    = "SnapshotFailed"
+	reasonSnapshotSkipped   = "SnapshotSkipped"
+)
+
+// recordApply records an Event for a child of m that was created or changed
+// by apply. Changes while the spec of m is unchanged are reverted drift.
+func (r *MyAppResourceReconciler) recordApply(m *appv1alpha1.MyAppResource, kind string, obj client.Object, result applyResult) {
+	switch result {
+	case applyCreated:
+		r.Recorder.Eventf(m, corev1.EventTypeNormal, reasonCreated, "Created %s %s", kind, obj.GetName())
+	case applyUpdated:
+		if m.Generation != m.Status.ObservedGeneration {
+			r.Recorder.Eventf(m, corev1.EventTypeNormal, reasonUpdated, "Updated %s %s", kind, obj.GetName())
+		} else {
+			r.Recorder.Eventf(m, corev1.EventTypeNormal, reasonDriftReverted, "Reverted changes made to %s %s", kind, obj.GetName())
+			driftCorrections.WithLabelValues(m.Namespace, m.Name, kind).Inc()
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestChangedByApply(t *testing.T) {
+	replicas := int32(1)
+	before := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-app-podinfo", ResourceVersion: "1", Generation: 1},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+
+	after := before.DeepCopy()
+	after.ResourceVersion = "2"
+	after.Status.ReadyReplicas = 1
+	require.False(t, changedByApply(before, after))
+
+	scaled := int32(3)
+	after.Spec.Replicas = &scaled
+	require.True(t, changedByApply(before, after))
+}
+
+func TestRecordApply(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := &MyAppResourceReconciler{Recorder: recorder}
+	m := &appv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: "example-app", Generation: 2}}
+	m.Status.ObservedGeneration = 1
+	d := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "example-app-podinfo"}}
+
+	r.recordApply(m, "Deployment", d, applyCreated)
+	require.Equal(t, "Normal Created Created Deployment example-app-podinfo", <-recorder.Events)
+
+	r.recordApply(m, "Deployment", d, applyUpdated)
+	require.Equal(t, "Normal Updated Updated Deployment example-app-podinfo", <-recorder.Events)
+
+	m.Status.ObservedGeneration = 2
+	r.recordApply(m, "Deployment", d, applyUpdated)
+	require.Equal(t, "Normal DriftReverted Reverted changes made to Deployment example-app-podinfo", <-recorder.Events)
+
+	r.recordApply(m, "Deployment", d, applyUnchanged)
+	require.Empty(t, recorder.Events)
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	batchv1ac "k8s.io/client-go/applyconfigurations/batch/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+// finalizerName keeps a MyAppResource around until spec.deletion is carried out.
+const finalizerName = "my.api.group.my.api.group/finalizer"
+
+// snapshotPollInterval is how often a running Redis snapshot Job is checked.
+const snapshotPollInterval = 5 * time.Second
+
+// finalize carries out spec.deletion for a MyAppResource that is being deleted
+// and removes the finalizer once it is done. Children are garbage collected
+// after that, unless they were orphaned.
+func (r *MyAppResourceReconciler) finalize(ctx context.Context, m *appv1alpha1.MyAppResource) (_ ctrl.Result, err error) {
+	if !controllerutil.ContainsFinalizer(m, finalizerName) {
+		return ctrl.Result{}, nil
+	}
+	ctx, span := r.startSpan(ctx, "finalize", m)
+	defer func() { endSpan(span, err) }()
+
+	log := r.logger(ctx).WithValues("myappresource", types.NamespacedName{Name: m.Name, Namespace: m.Namespace})
+
+	policy := deletionPolicy(m)
+	switch policy {
+	case appv1alpha1.DeletionOrphan:
+		if err := r.orphanChildren(ctx, m); err != nil {
+			log.Error(err, "Failed to orphan children")
+			r.setDeletionStatus(ctx, m, policy, appv1alpha1.DeletionFailed, "", err.Error())
+			r.Recorder.Eventf(m, corev1.EventTypeWarning, reasonOrphanFailed, "Failed to remove owner references: %v", err)
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Event(m, corev1.EventTypeNormal, reasonOrphaned, "Removed the owner references, the children are kept")
+
+	case appv1alpha1.DeletionSnapshot:
+		result, done, err := r.snapshotRedis(ctx, m)
+		if err != nil || !done {
+			return result, err
+		}
+	}
+
+	log.Info("Removing finalizer", "Policy", policy)
+	controllerutil.RemoveFinalizer(m, finalizerName)
+	if err := r.Update(ctx, m); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	r.Recorder.Eventf(m, corev1.EventTypeNormal, reasonDeleted, "Finalized with the %s deletion policy", policy)
+	return ctrl.Result{}, nil
+}
+
+// finalizeDryRun handles a MyAppResource that is being deleted in dry-run
+// mode. The Cascade policy only removes the finalizer, so it is carried out.
+// The other policies change the children, they are reported in
+// status.deletion and as an Event, and the finalizer is kept until dry-run
+// mode is turned off.
+func (r *MyAppResourceReconciler) finalizeDryRun(ctx context.Context, m *appv1alpha1.MyAppResource) (ctrl.Result, error) {
+	policy := deletionPolicy(m)
+	if policy == appv1alpha1.DeletionCascade {
+		return r.finalize(ctx, m)
+	}
+	if !controllerutil.ContainsFinalizer(m, finalizerName) {
+		return ctrl.Result{}, nil
+	}
+	if status := m.Status.Deletion; status != nil && status.Policy == policy && status.Phase == appv1alpha1.DeletionPending {
+		return ctrl.Result{}, nil
+	}
+
+	r.logger(ctx).Info("Not carrying out spec.deletion in dry-run mode", "Policy", policy,
+		"MyAppResource.Namespace", m.Namespace, "MyAppResource.Name", m.Name)
+	r.setDeletionStatus(ctx, m, policy, appv1alpha1.DeletionPending, "", "Waiting for dry-run mode to be turned off")
+	r.Recorder.Eventf(m, corev1.EventTypeNormal, reasonDryRun, "Would carry out the %s deletion policy, the finalizer is kept until dry-run mode is turned off", policy)
+	return ctrl.Result{}, nil
+}
+
+// deletionPolicy returns spec.deletion.policy of m, Cascade when it is not set.
+func deletionPolicy(m *appv1alpha1.MyAppResource) appv1alpha1.DeletionPolicy {
+	if m.Spec.Deletion.Policy == "" {
+		return appv1alpha1.DeletionCascade
+	}
+	return m.Spec.Deletion.Policy
+}
+
+// snapshotRedis runs a Job saving the Redis data set to the snapshot volume and
+// reports whether it has completed. A failed Job is reported in the status and
+// keeps the finalizer until spec.deletion is changed.
+func (r *MyAppResourceReconciler) snapshotRedis(ctx context.Context, m *appv1alpha1.MyAppResource) (ctrl.Result, bool, error) {
+	policy := appv1alpha1.DeletionSnapshot
+	if !m.Spec.Redis.Enabled {
+		r.Recorder.Event(m, corev1.EventTypeNormal, reasonSnapshotSkipped, "Redis is not enabled, there is nothing to snapshot")
+		return ctrl.Result{}, true, nil
+	}
+
+	snapshotJob := snapshotJobForRedis(m, r.config().Defaults.Redis.Image)
+	snapshotJob.WithOwnerReferences(ownerReference(m))
+	file := snapshotFile(m)
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: *snapshotJob.Name, Namespace: m.Namespace}}
+	if _, err := r.apply(ctx, job, snapshotJob); err != nil {
+		r.setDeletionStatus(ctx, m, policy, appv1alpha1.DeletionFailed, file, err.Error())
+		r.Recorder.Eventf(m, corev1.EventTypeWarning, reasonSnapshotFailed, "Failed to start snapshot Job %s: %v", job.Name, err)
+		return ctrl.Result{}, false, err
+	}
+
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			r.Recorder.Eventf(m, corev1.EventTypeNormal, reasonSnapshotCompleted, "Saved the Redis data set to %s on %s", file, m.Spec.Deletion.SnapshotClaimName)
+			return ctrl.Result{}, true, nil
+		case batchv1.JobFailed:
+			message := fmt.Sprintf("snapshot Job %s failed: %s", job.Name, condition.Message)
+			if m.Status.Deletion == nil || m.Status.Deletion.Phase != appv1alpha1.DeletionFailed {
+				r.Recorder.Event(m, corev1.EventTypeWarning, reasonSnapshotFailed, message)
+			}
+			r.setDeletionStatus(ctx, m, policy, appv1alpha1.DeletionFailed, file, message)
+			return ctrl.Result{}, false, nil
+		}
+	}
+
+	if m.Status.Deletion == nil {
+		r.Recorder.Eventf(m, corev1.EventTypeNormal, reasonSnapshotStarted, "Saving the Redis data set with Job %s", job.Name)
+	}
+	r.setDeletionStatus(ctx, m, policy, appv1alpha1.DeletionInProgress, file, "waiting for snapshot Job "+job.Name)
+	return ctrl.Result{RequeueAfter: snapshotPollInterval}, false, nil
+}
+
+// snapshotJobForRedis returns the Job copying the Redis data set of m to the
+// snapshot volume with redis-cli --rdb from the Redis image.
+func snapshotJobForRedis(m *appv1alpha1.MyAppResource, image string) *batchv1ac.JobApplyConfiguration {
+	labels := labelsForRedis(m.Name)
+	return batchv1ac.Job(redisName(m)+"-snapshot", m.Namespace).
+		WithLabels(labels).
+		WithSpec(batchv1ac.JobSpec().
+			WithBackoffLimit(2).
+			WithTemplate(corev1ac.PodTemplateSpec().
+				WithLabels(labels).
+				WithSpec(corev1ac.PodSpec().
+					WithRestartPolicy(corev1.RestartPolicyNever).
+					WithContainers(corev1ac.Container().
+						WithName("snapshot").
+						WithImage(image).
+						WithCommand("redis-cli", "-h", redisName(m), "-p", fmt.Sprint(redisPort), "--rdb", snapshotFile(m)).
+						WithVolumeMounts(corev1ac.VolumeMount().
+							WithName("snapshot").
+							WithMountPath("/snapshot"))).
+					WithVolumes(corev1ac.Volume().
+						WithName("snapshot").
+						WithPersistentVolumeClaim(corev1ac.PersistentVolumeClaimVolumeSource().
+							WithClaimName(m.Spec.Deletion.SnapshotClaimName))))))
+}
+
+// snapshotFile is the path of the Redis snapshot in the snapshot Job. It is
+// derived from the deletion time so the Job stays the same across reconciles.
+func snapshotFile(m *appv1alpha1.MyAppResource) string {
+	deleted := time.Time{}
+	if m.DeletionTimestamp != nil {
+		deleted = m.DeletionTimestamp.UTC()
+	}
+	return fmt.Sprintf("/snapshot/%s-%s.rdb", m.Name, deleted.Format("20060102T150405Z"))
+}
+
+// orphanChildren removes the owner reference to m from all of its children, so
+// the garbage collector keeps them once m is gone.
+func (r *MyAppResourceReconciler) orphanChildren(ctx context.Context, m *appv1alpha1.MyAppResource) error {
+	children := []struct {
+		obj  client.Object
+		name string
+	}{
+		{&appsv1.Deployment{}, m.Name + "-podinfo"},
+		{&corev1.Service{}, m.Name + "-podinfo"},
+		{&networkingv1.Ingress{}, m.Name + "-podinfo"},
+		{&appsv1.Deployment{}, redisName(m)},
+		{&corev1.Service{}, redisName(m)},
+	}
+
+	for _, child := range children {
+		obj := child.obj
+		err := r.Get(ctx, types.NamespacedName{Name: child.name, Namespace: m.Namespace}, obj)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		var ownerReferences []metav1.OwnerReference
+		for _, ref := range obj.GetOwnerReferences() {
+			if ref.UID != m.UID {
+				ownerReferences = append(ownerReferences, ref)
+			}
+		}
+		if len(ownerReferences) == len(obj.GetOwnerReferences()) {
+			continue
+		}
+
+		patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+		obj.SetOwnerReferences(ownerReferences)
+		r.logger(ctx).Info("Orphaning child", "Namespace", obj.GetNamespace(), "Name", obj.GetName())
+		if err := r.Patch(ctx, obj, patch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setDeletionStatus records the progress of spec.deletion. Errors are only
+// logged, the deletion is retried either way.
+func (r *MyAppResourceReconciler) setDeletionStatus(ctx context.Context, m *appv1alpha1.MyAppResource, policy appv1alpha1.DeletionPolicy, phase appv1alpha1.DeletionPhase, file, message string) {
+	status := &appv1alpha1.DeletionStatus{
+		Policy:       policy,
+		Phase:        phase,
+		SnapshotFile: file,
+		Message:      message,
+	}
+	if equality.Semantic.DeepEqual(m.Status.Deletion, status) {
+		return
+	}
+	m.Status.Deletion = status
+	if err := r.Status().Update(ctx, m); err != nil {
+		r.logger(ctx).Error(err, "Failed to record deletion status", "MyAppResource.Namespace", m.Namespace, "MyAppResource.Name", m.Name)
+	}
+}
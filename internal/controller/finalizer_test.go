@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func deletedMyAppResource(policy appv1alpha1.DeletionPolicy) *appv1alpha1.MyAppResource {
+	now := metav1.NewTime(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+	return &appv1alpha1.MyAppResource{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "example-app",
+			Namespace:         "default",
+			UID:               "1234",
+			Finalizers:        []string{finalizerName},
+			DeletionTimestamp: &now,
+		},
+		Spec: appv1alpha1.MyAppResourceSpec{
+			Deletion: appv1alpha1.Deletion{Policy: policy},
+		},
+	}
+}
+
+func TestFinalizeCascade(t *testing.T) {
+	ctx := context.Background()
+	m := deletedMyAppResource("")
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(m).WithStatusSubresource(m).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &MyAppResourceReconciler{Client: c, Log: ctrl.Log.WithName("test"), Recorder: recorder}
+
+	_, err := r.finalize(ctx, m)
+	require.NoError(t, err)
+	require.True(t, errors.IsNotFound(c.Get(ctx, types.NamespacedName{Name: m.Name, Namespace: m.Namespace}, &appv1alpha1.MyAppResource{})))
+	require.Equal(t, "Normal Deleted Finalized with the Cascade deletion policy", <-recorder.Events)
+}
+
+func TestFinalizeOrphan(t *testing.T) {
+	ctx := context.Background()
+	m := deletedMyAppResource(appv1alpha1.DeletionOrphan)
+	controller := true
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Name:      "example-app-podinfo",
+		Namespace: "default",
+		OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: appv1alpha1.GroupVersion.String(), Kind: "MyAppResource", Name: m.Name, UID: m.UID, Controller: &controller},
+			{APIVersion: "v1", Kind: "ConfigMap", Name: "other", UID: "5678"},
+		},
+	}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(m, deployment).WithStatusSubresource(m).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &MyAppResourceReconciler{Client: c, Log: ctrl.Log.WithName("test"), Recorder: recorder}
+
+	_, err := r.finalize(ctx, m)
+	require.NoError(t, err)
+
+	found := &appsv1.Deployment{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, found))
+	require.Len(t, found.OwnerReferences, 1)
+	require.Equal(t, types.UID("5678"), found.OwnerReferences[0].UID)
+	require.Equal(t, "Normal Orphaned Removed the owner references, the children are kept", <-recorder.Events)
+}
+
+func TestFinalizeSnapshotWithoutRedis(t *testing.T) {
+	ctx := context.Background()
+	m := deletedMyAppResource(appv1alpha1.DeletionSnapshot)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(m).WithStatusSubresource(m).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &MyAppResourceReconciler{Client: c, Log: ctrl.Log.WithName("test"), Recorder: recorder}
+
+	_, err := r.finalize(ctx, m)
+	require.NoError(t, err)
+	require.Contains(t, <-recorder.Events, "SnapshotSkipped")
+	require.Contains(t, <-recorder.Events, "Deleted")
+}
+
+func TestSnapshotJobForRedis(t *testing.T) {
+	m := deletedMyAppResource(appv1alpha1.DeletionSnapshot)
+	m.Spec.Deletion.SnapshotClaimName = "redis-snapshots"
+
+	job := snapshotJobForRedis(m, "redis:7")
+	require.Equal(t, "example-app-redis-snapshot", *job.Name)
+	container := job.Spec.Template.Spec.Containers[0]
+	require.Equal(t, "redis:7", *container.Image)
+	require.Equal(t, []string{"redis-cli", "-h", "example-app-redis", "-p", "6379", "--rdb", "/snapshot/example-app-20231001T120000Z.rdb"}, container.Command)
+	require.Equal(t, "redis-snapshots", *job.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+	require.Equal(t, corev1.RestartPolicyNever, *job.Spec.Template.Spec.RestartPolicy)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	networkingv1ac "k8s.io/client-go/applyconfigurations/networking/v1"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+// reconcileIngress makes sure the podinfo Ingress matches spec.ingress, and
+// removes it when the Ingress is disabled.
+func (r *MyAppResourceReconciler) reconcileIngress(ctx context.Context, m *appv1alpha1.MyAppResource) (err error) {
+	ctx, span := r.startSpan(ctx, "ingress", m)
+	defer func() { endSpan(span, err) }()
+
+	if !m.Spec.Ingress.Enabled {
+		return r.deleteOwned(ctx, m, &networkingv1.Ingress{}, m.Name+"-podinfo")
+	}
+
+	log := r.logger(ctx).WithValues("myappresource", types.NamespacedName{Name: m.Name, Namespace: m.Namespace})
+
+	// The IngressClass is only applied when spec.ingress.className is set, so
+	// the cluster default assigned on creation is kept otherwise
+	podinfoIngress := r.ingressForPodinfo(m)
+	podinfoIngress.WithOwnerReferences(ownerReference(m))
+
+	log.Info("Applying Ingress", "Ingress.Namespace", m.Namespace, "Ingress.Name", *podinfoIngress.Name)
+	found := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: *podinfoIngress.Name, Namespace: m.Namespace}}
+	result, err := r.apply(ctx, found, podinfoIngress)
+	if err != nil {
+		return err
+	}
+	r.recordApply(m, "Ingress", found, result)
+	return nil
+}
+
+func (r *MyAppResourceReconciler) ingressForPodinfo(m *appv1alpha1.MyAppResource) *networkingv1ac.IngressApplyConfiguration {
+	spec := m.Spec.Ingress
+	name := m.Name + "-podinfo"
+
+	paths := spec.Paths
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+	httpPaths := make([]*networkingv1ac.HTTPIngressPathApplyConfiguration, 0, len(paths))
+	for _, path := range paths {
+		httpPaths = append(httpPaths, networkingv1ac.HTTPIngressPath().
+			WithPath(path).
+			WithPathType(networkingv1.PathTypePrefix).
+			WithBackend(networkingv1ac.IngressBackend().
+				WithService(networkingv1ac.IngressServiceBackend().
+					WithName(name).
+					WithPort(networkingv1ac.ServiceBackendPort().WithName("http")))))
+	}
+
+	hosts := spec.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+	ingressSpec := networkingv1ac.IngressSpec()
+	for _, host := range hosts {
+		rule := networkingv1ac.IngressRule().
+			WithHTTP(networkingv1ac.HTTPIngressRuleValue().WithPaths(httpPaths...))
+		if host != "" {
+			rule.WithHost(host)
+		}
+		ingressSpec.WithRules(rule)
+	}
+
+	if spec.ClassName != nil {
+		ingressSpec.WithIngressClassName(*spec.ClassName)
+	}
+	if spec.TLSSecretName != "" {
+		ingressSpec.WithTLS(networkingv1ac.IngressTLS().
+			WithHosts(spec.Hosts...).
+			WithSecretName(spec.TLSSecretName))
+	}
+
+	ingress := networkingv1ac.Ingress(name, m.Namespace).
+		WithLabels(labelsForPodinfo(m.Name)).
+		WithSpec(ingressSpec)
+	if len(spec.Annotations) > 0 {
+		ingress.WithAnnotations(spec.Annotations)
+	}
+	return ingress
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+// Metrics of the managed applications, labelled with the namespace and name
+// of their MyAppResource. They are served with the controller-runtime metrics.
+var (
+	desiredReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "myappresource_desired_replicas",
+		Help: "Number of podinfo replicas requested by the spec.",
+	}, []string{"namespace", "name"})
+
+	readyReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "myappresource_ready_replicas",
+		Help: "Number of ready podinfo replicas.",
+	}, []string{"namespace", "name"})
+
+	driftCorrections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "myappresource_drift_corrections_total",
+		Help: "Number of child objects changed by someone else and reverted by the controller.",
+	}, []string{"namespace", "name", "kind"})
+
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "myappresource_reconcile_total",
+		Help: "Number of reconciles by result and reason, the reason of a failure is the reason of the Degraded condition.",
+	}, []string{"namespace", "name", "result", "reason"})
+
+	lastRolloutTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "myappresource_last_successful_rollout_timestamp_seconds",
+		Help: "Unix time at which the last podinfo rollout completed.",
+	}, []string{"namespace", "name"})
+
+	redisEnabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "myappresource_redis_enabled",
+		Help: "Whether the managed Redis is enabled (1) or not (0).",
+	}, []string{"namespace", "name"})
+
+	redisHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "myappresource_redis_healthy",
+		Help: "Whether the managed Redis has available replicas (1) or not (0).",
+	}, []string{"namespace", "name"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		desiredReplicas,
+		readyReplicas,
+		driftCorrections,
+		reconcileTotal,
+		lastRolloutTimestamp,
+		redisEnabled,
+		redisHealthy,
+	)
+}
+
+// recordReconcile counts the outcome of a reconcile of m, and drops all series
+// of m once it is gone.
+func recordReconcile(key types.NamespacedName, m *appv1alpha1.MyAppResource, err error) {
+	notFound := m.UID == "" && err == nil
+	if notFound || (m.DeletionTimestamp != nil && !controllerutil.ContainsFinalizer(m, finalizerName)) {
+		deleteMetrics(key)
+		return
+	}
+
+	result, reason := "success", "Reconciled"
+	if err != nil {
+		result, reason = "error", "Error"
+		// markDegraded records the reason of the failure with the same message
+		if c := meta.FindStatusCondition(m.Status.Conditions, appv1alpha1.ConditionDegraded); c != nil &&
+			c.Status == metav1.ConditionTrue && c.Message == err.Error() {
+			reason = c.Reason
+		}
+	}
+	reconcileTotal.WithLabelValues(key.Namespace, key.Name, result, reason).Inc()
+}
+
+// recordStatusMetrics exports the observed state of m.
+func recordStatusMetrics(m *appv1alpha1.MyAppResource, status *appv1alpha1.MyAppResourceStatus) {
+	desiredReplicas.WithLabelValues(m.Namespace, m.Name).Set(float64(status.DesiredReplicas))
+	readyReplicas.WithLabelValues(m.Namespace, m.Name).Set(float64(status.ReadyReplicas))
+
+	if c := meta.FindStatusCondition(status.Conditions, appv1alpha1.ConditionProgressing); c != nil && c.Status == metav1.ConditionFalse {
+		lastRolloutTimestamp.WithLabelValues(m.Namespace, m.Name).Set(float64(c.LastTransitionTime.Unix()))
+	}
+
+	enabled, healthy := 0.0, 0.0
+	if m.Spec.Redis.Enabled {
+		enabled = 1
+		if meta.IsStatusConditionTrue(status.Conditions, appv1alpha1.ConditionCacheReady) {
+			healthy = 1
+		}
+	}
+	redisEnabled.WithLabelValues(m.Namespace, m.Name).Set(enabled)
+	redisHealthy.WithLabelValues(m.Namespace, m.Name).Set(healthy)
+}
+
+// deleteMetrics removes all series of the MyAppResource key.
+func deleteMetrics(key types.NamespacedName) {
+	labels := prometheus.Labels{"namespace": key.Namespace, "name": key.Name}
+	for _, vec := range []interface {
+		DeletePartialMatch(prometheus.Labels) int
+	}{
+		desiredReplicas,
+		readyReplicas,
+		driftCorrections,
+		reconcileTotal,
+		lastRolloutTimestamp,
+		redisEnabled,
+		redisHealthy,
+	} {
+		vec.DeletePartialMatch(labels)
+	}
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRecordReconcile(t *testing.T) {
+	key := types.NamespacedName{Name: "metrics-app", Namespace: "default"}
+	m := &appv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace, UID: "1234"}}
+
+	recordReconcile(key, m, nil)
+	require.Equal(t, 1.0, testutil.ToFloat64(reconcileTotal.WithLabelValues("default", "metrics-app", "success", "Reconciled")))
+
+	err := errors.New("bad quantity")
+	meta.SetStatusCondition(&m.Status.Conditions, metav1.Condition{
+		Type:    appv1alpha1.ConditionDegraded,
+		Status:  metav1.ConditionTrue,
+		Reason:  "InvalidSpec",
+		Message: err.Error(),
+	})
+	recordReconcile(key, m, err)
+	require.Equal(t, 1.0, testutil.ToFloat64(reconcileTotal.WithLabelValues("default", "metrics-app", "error", "InvalidSpec")))
+
+	recordReconcile(key, m, errors.New("connection refused"))
+	require.Equal(t, 1.0, testutil.ToFloat64(reconcileTotal.WithLabelValues("default", "metrics-app", "error", "Error")))
+
+	// The series are dropped once the resource is gone
+	recordReconcile(key, &appv1alpha1.MyAppResource{}, nil)
+	require.Zero(t, testutil.CollectAndCount(reconcileTotal))
+}
+
+func TestRecordStatusMetrics(t *testing.T) {
+	m := &appv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: "status-app", Namespace: "default"}}
+	m.Spec.Redis.Enabled = true
+	status := &appv1alpha1.MyAppResourceStatus{DesiredReplicas: 3, ReadyReplicas: 2}
+	rolledOut := metav1.Unix(1696161600, 0)
+	status.Conditions = []metav1.Condition{
+		{Type: appv1alpha1.ConditionProgressing, Status: metav1.ConditionFalse, LastTransitionTime: rolledOut},
+		{Type: appv1alpha1.ConditionCacheReady, Status: metav1.ConditionTrue},
+	}
+
+	recordStatusMetrics(m, status)
+	require.Equal(t, 3.0, testutil.ToFloat64(desiredReplicas.WithLabelValues("default", "status-app")))
+	require.Equal(t, 2.0, testutil.ToFloat64(readyReplicas.WithLabelValues("default", "status-app")))
+	require.Equal(t, 1696161600.0, testutil.ToFloat64(lastRolloutTimestamp.WithLabelValues("default", "status-app")))
+	require.Equal(t, 1.0, testutil.ToFloat64(redisEnabled.WithLabelValues("default", "status-app")))
+	require.Equal(t, 1.0, testutil.ToFloat64(redisHealthy.WithLabelValues("default", "status-app")))
+
+	deleteMetrics(types.NamespacedName{Name: "status-app", Namespace: "default"})
+	require.Zero(t, testutil.CollectAndCount(desiredReplicas))
+}
@@ -18,37 +18,113 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	metav1ac "k8s.io/client-go/applyconfigurations/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 
 	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	"github.com/sumyann/k8s-controller/internal/config"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+const (
+	// envCacheServer is owned by the controller, see cacheServerAddress
+	envCacheServer = "PODINFO_CACHE_SERVER"
 )
 
 // MyAppResourceReconciler reconciles a MyAppResource object
 type MyAppResourceReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// TracerProvider creates the spans of the reconciles, the global
+	// provider is used when it is nil.
+	TracerProvider trace.TracerProvider
+
+	// WatchNamespaces are the namespaces the manager cache is restricted to,
+	// all namespaces are reconciled when it is empty.
+	WatchNamespaces []string
+
+	// Shard selects the MyAppResources reconciled by this replica, all of
+	// them are reconciled when it is nil.
+	Shard *Shard
+
+	// Config serves the defaults and feature gates of the configuration file,
+	// which are reloaded while the controller runs. The built-in defaults are
+	// used when it is nil.
+	Config *config.Store
+
+	// DryRun only plans the changes to the children of all MyAppResources,
+	// see plan.
+	DryRun bool
+
+	// Clock is used for scheduled restarts, the system clock is used when
+	// it is nil.
+	Clock clock.PassiveClock
 }
 
-// +kubebuilder:rbac:groups=app.example.com,resources=myappresources,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=app.example.com,resources=myappresources/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=my.api.group.my.api.group,resources=myappresources,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=my.api.group.my.api.group,resources=myappresources/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps;secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+func (r *MyAppResourceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, span := r.tracer().Start(ctx, "Reconcile", trace.WithAttributes(
+		attribute.String("myappresource.namespace", req.Namespace),
+		attribute.String("myappresource.name", req.Name),
+	))
+	defer func() { endSpan(span, err) }()
 
-func (r *MyAppResourceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	log := r.Log.WithValues("myappresource", req.NamespacedName)
+	myAppResource := &appv1alpha1.MyAppResource{}
+	result, err = r.reconcile(ctx, req, myAppResource)
+	recordReconcile(req.NamespacedName, myAppResource, err)
+	return result, err
+}
+
+// reconcile does the work of Reconcile, reading the MyAppResource into
+// myAppResource so its outcome can be recorded.
+func (r *MyAppResourceReconciler) reconcile(ctx context.Context, req ctrl.Request, myAppResource *appv1alpha1.MyAppResource) (ctrl.Result, error) {
+	log := r.logger(ctx).WithValues("myappresource", req.NamespacedName)
 	log.Info("Starting reconciliation", "namespace", req.NamespacedName.Namespace, "name", req.NamespacedName.Name)
 
+	// The cache cannot serve other namespaces and we have no permissions there
+	if !r.watchesNamespace(req.Namespace) {
+		log.Info("Ignoring MyAppResource outside of the watched namespaces", "WatchNamespaces", r.WatchNamespaces)
+		return ctrl.Result{}, nil
+	}
+
 	// Fetch the MyAppResource instance
-	myAppResource := &appv1alpha1.MyAppResource{}
 	err := r.Get(ctx, req.NamespacedName, myAppResource)
 	if err != nil {
 		if errors.IsNotFound(err) {
@@ -61,58 +137,67 @@ func (r *MyAppResourceReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{}, err
 	}
 
-	// Define a new Podinfo deployment
-	podinfoDeployment := r.deploymentForPodinfo(myAppResource)
-	// Set MyAppResource instance as the owner and controller
-	ctrl.SetControllerReference(myAppResource, podinfoDeployment, r.Scheme)
-
-	// Check if this Podinfo Deployment already exists
-	found := &appsv1.Deployment{}
-	err = r.Get(ctx, types.NamespacedName{Name: podinfoDeployment.Name, Namespace: podinfoDeployment.Namespace}, found)
+	// Leave resources of other shards alone, and take over the ones that
+	// moved to this shard before changing them
+	if !r.Shard.Contains(req.NamespacedName, myAppResource.Labels) {
+		log.Info("Ignoring MyAppResource of another shard", "Shard", r.Shard.String())
+		return ctrl.Result{}, nil
+	}
+	if err = r.claimShard(ctx, myAppResource); err != nil {
+		log.Error(err, "Failed to claim MyAppResource for shard", "Shard", r.Shard.String())
+		return ctrl.Result{}, err
+	}
 
-	if err != nil && errors.IsNotFound(err) {
-		log.Info("Creating a new Deployment", "Deployment.Namespace", podinfoDeployment.Namespace, "Deployment.Name", podinfoDeployment.Name)
-		err = r.Create(ctx, podinfoDeployment)
-		if err != nil {
-			log.Error(err, "Failed to create new Deployment", "Deployment.Namespace", podinfoDeployment.Namespace, "Deployment.Name", podinfoDeployment.Name)
-			return ctrl.Result{}, err
+	// Only report what would change in dry-run mode. A resource being deleted
+	// keeps its finalizer until dry-run mode is turned off, unless the
+	// deletion policy leaves the children to the garbage collector.
+	if r.dryRun(myAppResource) {
+		if !myAppResource.DeletionTimestamp.IsZero() {
+			return r.finalizeDryRun(ctx, myAppResource)
 		}
-		// Deployment created successfully - return and requeue
-		return ctrl.Result{Requeue: true}, nil
-	} else if err != nil {
-		log.Error(err, "Failed to get Deployment")
-		return ctrl.Result{}, err
-	} else {
-		// Update the deployment if necessary
-		updateNeeded := false
-
-		// Check and update the replica count
-		replicaCount := myAppResource.Spec.ReplicaCount
-		if *found.Spec.Replicas != replicaCount {
-			found.Spec.Replicas = &replicaCount
-			updateNeeded = true
+		if err = r.plan(ctx, myAppResource); err != nil {
+			log.Error(err, "Failed to plan changes", "MyAppResource.Namespace", myAppResource.Namespace, "MyAppResource.Name", myAppResource.Name)
+			return ctrl.Result{}, err
 		}
+		return ctrl.Result{}, nil
+	}
 
-		// Check and update the environment variables
-		mergedEnvVars := r.mergeEnvVars(myAppResource, found)
-		if !equalEnvVars(found.Spec.Template.Spec.Containers[0].Env, mergedEnvVars) {
-			found.Spec.Template.Spec.Containers[0].Env = mergedEnvVars
-			updateNeeded = true
+	// Carry out spec.deletion before the children are garbage collected
+	if !myAppResource.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, myAppResource)
+	}
+	if controllerutil.AddFinalizer(myAppResource, finalizerName) {
+		if err = r.Update(ctx, myAppResource); err != nil {
+			log.Error(err, "Failed to add finalizer", "MyAppResource.Namespace", myAppResource.Namespace, "MyAppResource.Name", myAppResource.Name)
+			return ctrl.Result{}, err
 		}
+	}
 
-		// If an update is needed, update the deployment
-		if updateNeeded {
-			log.Info("Updating Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
-			err = r.Update(ctx, found)
-			if err != nil {
-				log.Error(err, "Failed to update Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
-				return ctrl.Result{}, err
-			}
-			return ctrl.Result{Requeue: true}, nil
+	// Leave the children alone while paused, unless a sync is requested
+	var (
+		found          *appsv1.Deployment
+		podinfoService *corev1.Service
+	)
+	var requeueAfter time.Duration
+	syncing := syncRequested(myAppResource)
+	if by := pausedBy(myAppResource); by != "" && !syncing {
+		log.Info("Reconciliation is paused, not changing the children", "PausedBy", by)
+		if found, podinfoService, err = r.observeChildren(ctx, myAppResource); err != nil {
+			log.Error(err, "Failed to get children", "MyAppResource.Namespace", myAppResource.Namespace, "MyAppResource.Name", myAppResource.Name)
+			return ctrl.Result{}, err
+		}
+	} else {
+		// Record a due restart before the pod templates are stamped with it
+		if requeueAfter, err = r.scheduleRestart(ctx, myAppResource); err != nil {
+			log.Error(err, "Failed to schedule restart", "MyAppResource.Namespace", myAppResource.Namespace, "MyAppResource.Name", myAppResource.Name)
+			return ctrl.Result{}, err
+		}
+		if found, podinfoService, err = r.reconcileChildren(ctx, myAppResource); err != nil {
+			return ctrl.Result{}, err
 		}
 	}
 
-	// Update the MyAppResource status with the pod names
+	// Update the MyAppResource status with the pod details
 	podList := &corev1.PodList{}
 	listOpts := []client.ListOption{
 		client.InNamespace(myAppResource.Namespace),
@@ -123,157 +208,391 @@ func (r *MyAppResourceReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{}, err
 	}
 
+	if err = r.updateStatus(ctx, myAppResource, found, podinfoService, podList.Items); err != nil {
+		log.Error(err, "Failed to update MyAppResource status", "MyAppResource.Namespace", myAppResource.Namespace, "MyAppResource.Name", myAppResource.Name)
+		return ctrl.Result{}, err
+	}
+	if syncing {
+		r.Recorder.Eventf(myAppResource, corev1.EventTypeNormal, reasonSynced, "Synced the children as requested by sync-now %q", myAppResource.Status.LastHandledSyncNow)
+	}
+
 	log.Info("Ending reconciliation", "namespace", req.NamespacedName.Namespace, "name", req.NamespacedName.Name)
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
-// mergeEnvVars merges the environment variables from the CR and the existing deployment,
-// updating or appending the environment variables from the CR.
-func (r *MyAppResourceReconciler) mergeEnvVars(m *appv1alpha1.MyAppResource, d *appsv1.Deployment) []corev1.EnvVar {
-	// Create a map to hold the final set of environment variables
-	envVarMap := make(map[string]corev1.EnvVar)
+// reconcileChildren applies the children of m in dependency order, and returns
+// the live podinfo Deployment and Service. Failures are recorded in the
+// Degraded condition.
+func (r *MyAppResourceReconciler) reconcileChildren(ctx context.Context, m *appv1alpha1.MyAppResource) (*appsv1.Deployment, *corev1.Service, error) {
+	log := r.logger(ctx).WithValues("myappresource", types.NamespacedName{Name: m.Name, Namespace: m.Namespace})
+
+	// Reconcile the Redis Deployment and Service before podinfo, which uses it as a cache
+	if err := r.reconcileRedis(ctx, m); err != nil {
+		log.Error(err, "Failed to reconcile Redis", "MyAppResource.Namespace", m.Namespace, "MyAppResource.Name", m.Name)
+		r.markDegraded(ctx, m, "RedisFailed", err)
+		return nil, nil, err
+	}
 
-	// Populate the map with environment variables from the existing deployment
-	for _, envVar := range d.Spec.Template.Spec.Containers[0].Env {
-		envVarMap[envVar.Name] = envVar
+	// Reconcile the Service exposing podinfo
+	podinfoService, err := r.reconcileService(ctx, m)
+	if err != nil {
+		log.Error(err, "Failed to reconcile Service", "MyAppResource.Namespace", m.Namespace, "MyAppResource.Name", m.Name)
+		r.markDegraded(ctx, m, "ServiceFailed", err)
+		return nil, nil, err
 	}
 
-	// Update the map with environment variables from the CR, which will
-	// overwrite any existing environment variables with the same name
-	for _, envVar := range m.Spec.Env {
-		envVarMap[envVar.Name] = envVar
+	// Reconcile the Ingress routing to the podinfo Service
+	if err = r.reconcileIngress(ctx, m); err != nil {
+		log.Error(err, "Failed to reconcile Ingress", "MyAppResource.Namespace", m.Namespace, "MyAppResource.Name", m.Name)
+		r.markDegraded(ctx, m, "IngressFailed", err)
+		return nil, nil, err
 	}
 
-	// Convert the map back to a slice
-	mergedEnvVars := make([]corev1.EnvVar, 0, len(envVarMap))
-	for _, envVar := range envVarMap {
-		mergedEnvVars = append(mergedEnvVars, envVar)
+	// Reconcile the podinfo Deployment
+	found, err := r.reconcilePodinfo(ctx, m)
+	if err != nil {
+		return nil, nil, err
 	}
+	return found, podinfoService, nil
+}
 
-	return mergedEnvVars
+// config returns the configuration applying to the current reconcile.
+func (r *MyAppResourceReconciler) config() *config.Config {
+	return r.Config.Get()
 }
 
-// equalEnvVars checks if two slices of environment variables are equal.
-func equalEnvVars(envVars1, envVars2 []corev1.EnvVar) bool {
-	if len(envVars1) != len(envVars2) {
-		return false
+// watchesNamespace reports whether MyAppResources in namespace are reconciled.
+func (r *MyAppResourceReconciler) watchesNamespace(namespace string) bool {
+	if len(r.WatchNamespaces) == 0 {
+		return true
 	}
+	for _, watched := range r.WatchNamespaces {
+		if watched == namespace {
+			return true
+		}
+	}
+	return false
+}
 
-	// Convert slices to maps for easier comparison
-	envVarMap1 := make(map[string]string, len(envVars1))
-	envVarMap2 := make(map[string]string, len(envVars2))
+// reconcilePodinfo applies the podinfo Deployment, rolling its pods when the
+// referenced configuration changes and pruning env vars removed from the spec.
+// It returns the live Deployment.
+func (r *MyAppResourceReconciler) reconcilePodinfo(ctx context.Context, m *appv1alpha1.MyAppResource) (_ *appsv1.Deployment, err error) {
+	ctx, span := r.startSpan(ctx, "podinfo", m)
+	defer func() { endSpan(span, err) }()
 
-	for _, envVar := range envVars1 {
-		envVarMap1[envVar.Name] = envVar.Value
+	log := r.logger(ctx).WithValues("myappresource", types.NamespacedName{Name: m.Name, Namespace: m.Namespace})
+
+	// Define a new Podinfo deployment
+	podinfoDeployment, err := r.deploymentForPodinfo(m)
+	if err != nil {
+		log.Error(err, "Failed to build Deployment", "MyAppResource.Namespace", m.Namespace, "MyAppResource.Name", m.Name)
+		r.markDegraded(ctx, m, "InvalidSpec", err)
+		return nil, err
 	}
-	for _, envVar := range envVars2 {
-		envVarMap2[envVar.Name] = envVar.Value
+	// Set MyAppResource instance as the owner and controller
+	podinfoDeployment.WithOwnerReferences(ownerReference(m))
+
+	// Roll the pods when referenced ConfigMaps or Secrets change
+	if r.config().Enabled(config.ConfigChecksum) {
+		checksum, err := r.configChecksum(ctx, m)
+		if err != nil {
+			log.Error(err, "Failed to compute config checksum", "MyAppResource.Namespace", m.Namespace, "MyAppResource.Name", m.Name)
+			return nil, err
+		}
+		if checksum != "" {
+			podinfoDeployment.Spec.Template.WithAnnotations(map[string]string{configChecksumAnnotation: checksum})
+		}
 	}
 
-	// Compare maps
-	for key, value := range envVarMap1 {
-		if envVarMap2[key] != value {
-			return false
+	// Remember the env vars set by the previous apply before it is overwritten,
+	// stale ones stay recorded until they are pruned
+	var managedEnv []string
+	live := &appsv1.Deployment{}
+	err = r.Get(ctx, types.NamespacedName{Name: *podinfoDeployment.Name, Namespace: *podinfoDeployment.Namespace}, live)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Deployment")
+		return nil, err
+	}
+	exists := err == nil
+	if exists {
+		managedEnv = managedEnvNames(live)
+	}
+	keepStaleEnvVars(podinfoDeployment, live, managedEnv, podinfoEnvVars(m))
+
+	// Roll back a failed rollout of the current spec, and keep the last good
+	// pod template until the spec changes
+	var rollingBack bool
+	if exists {
+		if rollingBack, err = r.trackRollout(ctx, m, live); err != nil {
+			log.Error(err, "Failed to track rollout", "Deployment.Namespace", live.Namespace, "Deployment.Name", live.Name)
+			return nil, err
 		}
 	}
+	if exists && rolledBack(m) {
+		template, err := r.lastGoodTemplate(ctx, m, live)
+		if err != nil {
+			log.Error(err, "Failed to get last good pod template", "Deployment.Namespace", live.Namespace, "Deployment.Name", live.Name)
+			return nil, err
+		}
+		if template != nil {
+			podinfoDeployment.Spec.WithTemplate(template)
+		} else {
+			log.Info("Not keeping the rollback, the last good ReplicaSet no longer exists", "Deployment.Namespace", live.Namespace, "Deployment.Name", live.Name)
+			rollingBack = false
+		}
+	}
+
+	// Apply the Podinfo Deployment, creating it or correcting any drift of
+	// the fields we own
+	found := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: *podinfoDeployment.Name, Namespace: *podinfoDeployment.Namespace}}
+	log.Info("Applying Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+	result, err := r.apply(ctx, found, podinfoDeployment)
+	if err != nil {
+		log.Error(err, "Failed to apply Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+		r.markDegraded(ctx, m, "ApplyFailed", err)
+		return nil, err
+	}
+	if rollingBack {
+		r.Recorder.Eventf(m, corev1.EventTypeWarning, reasonRolledBack, "Rolled back Deployment %s to pod template %s: %s",
+			found.Name, m.Status.Rollout.LastGoodTemplateHash, m.Status.Rollout.Message)
+	} else if result == applyUpdated && m.Generation == m.Status.ObservedGeneration &&
+		live.Spec.Template.Annotations[configChecksumAnnotation] != found.Spec.Template.Annotations[configChecksumAnnotation] {
+		r.Recorder.Eventf(m, corev1.EventTypeNormal, reasonConfigChanged, "Rolling Deployment %s, referenced ConfigMaps or Secrets changed", found.Name)
+	} else if !(result == applyUpdated && restarted(live, found)) {
+		r.recordApply(m, "Deployment", found, result)
+	}
+	if result == applyUpdated && live.Spec.Replicas != nil && found.Spec.Replicas != nil && *live.Spec.Replicas != *found.Spec.Replicas {
+		r.Recorder.Eventf(m, corev1.EventTypeNormal, reasonScaled, "Scaled Deployment %s from %d to %d replicas", found.Name, *live.Spec.Replicas, *found.Spec.Replicas)
+	}
+
+	// Prune env vars removed from the spec that another manager still holds
+	if stale := staleEnvVars(found, managedEnv, podinfoEnvVars(m)); len(stale) > 0 && r.config().Enabled(config.EnvPruning) {
+		log.Info("Pruning env vars removed from spec.env", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name, "EnvVars", stale)
+		if err = r.pruneEnvVars(ctx, found, stale); err != nil {
+			log.Error(err, "Failed to prune env vars", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+			r.markDegraded(ctx, m, "ApplyFailed", err)
+			return nil, err
+		}
+		r.Recorder.Eventf(m, corev1.EventTypeNormal, reasonEnvPruned, "Removed env vars %s from Deployment %s", strings.Join(stale, ", "), found.Name)
+	}
 
-	return true
+	return found, nil
 }
 
-func (r *MyAppResourceReconciler) deploymentForPodinfo(m *appv1alpha1.MyAppResource) *appsv1.Deployment {
+func (r *MyAppResourceReconciler) deploymentForPodinfo(m *appv1alpha1.MyAppResource) (*appsv1ac.DeploymentApplyConfiguration, error) {
 	labels := labelsForPodinfo(m.Name)
 
+	defaults := r.config().Defaults.Podinfo
+	resources, err := resourceRequirements(m.Spec.Resources)
+	if err != nil {
+		return nil, fmt.Errorf("spec.resources: %w", err)
+	}
+	if len(resources.Requests) == 0 && len(resources.Limits) == 0 {
+		resources = *defaults.Resources.DeepCopy()
+	}
+
+	if hasEnvVar(m.Spec.Env, envCacheServer) {
+		r.Log.Info("Ignoring "+envCacheServer+" from spec.env, it is derived from spec.redis and spec.cacheServer",
+			"MyAppResource.Namespace", m.Namespace, "MyAppResource.Name", m.Name)
+	}
+
 	// Merge environment variables from the env field with other environment variables
-	envVars := append(m.Spec.Env, []corev1.EnvVar{
-		{
-			Name:  "PODINFO_UI_COLOR",
-			Value: m.Spec.UI.Color,
-		},
-		{
-			Name:  "PODINFO_UI_MESSAGE",
-			Value: m.Spec.UI.Message,
-		},
-	}...)
-
-	return &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      m.Name + "-podinfo",
-			Namespace: m.Namespace,
-			Labels:    labels,
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &m.Spec.ReplicaCount,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: labels,
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
-				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:  "podinfo",
-							Image: "ghcr.io/stefanprodan/podinfo:latest",
-							Env:   envVars, // to use merged environment variables
-						},
-					},
-				},
-			},
-		},
+	envVars := podinfoEnvVars(m)
+
+	deployment := appsv1ac.Deployment(m.Name+"-podinfo", m.Namespace).
+		WithLabels(labels).
+		WithAnnotations(map[string]string{managedEnvAnnotation: envVarNames(envVars)}).
+		WithSpec(appsv1ac.DeploymentSpec().
+			WithReplicas(m.Spec.ReplicaCount).
+			WithSelector(metav1ac.LabelSelector().
+				WithMatchLabels(labels)).
+			WithTemplate(corev1ac.PodTemplateSpec().
+				WithLabels(labels).
+				WithSpec(corev1ac.PodSpec().
+					WithContainers(corev1ac.Container().
+						WithName("podinfo").
+						WithImage(podinfoImage(m, defaults)).
+						WithPorts(corev1ac.ContainerPort().
+							WithName("http").
+							WithContainerPort(podinfoPort).
+							WithProtocol(corev1.ProtocolTCP)).
+						WithEnv(envVarApplyConfigurations(envVars)...).
+						WithEnvFrom(envFromApplyConfigurations(m.Spec.EnvFrom)...).
+						WithResources(resourceRequirementsApplyConfiguration(resources))))))
+	applyRollout(deployment.Spec, m.Spec.Rollout)
+	stampRestart(deployment.Spec.Template, m)
+	return deployment, nil
+}
+
+// podinfoEnvVars returns the env of the podinfo container: the user supplied
+// spec.env followed by the variables derived from the rest of the spec. Names
+// are unique, a later entry replaces an earlier one in place, so the order
+// only depends on the spec.
+func podinfoEnvVars(m *appv1alpha1.MyAppResource) []corev1.EnvVar {
+	envVars := make([]corev1.EnvVar, 0, len(m.Spec.Env)+3)
+	index := make(map[string]int, len(m.Spec.Env)+3)
+	add := func(envVar corev1.EnvVar) {
+		if i, ok := index[envVar.Name]; ok {
+			envVars[i] = envVar
+			return
+		}
+		index[envVar.Name] = len(envVars)
+		envVars = append(envVars, envVar)
+	}
+
+	for _, envVar := range m.Spec.Env {
+		if envVar.Name == envCacheServer {
+			continue
+		}
+		add(envVar)
+	}
+
+	add(corev1.EnvVar{
+		Name:  "PODINFO_UI_COLOR",
+		Value: m.Spec.UI.Color,
+	})
+	add(corev1.EnvVar{
+		Name:  "PODINFO_UI_MESSAGE",
+		Value: m.Spec.UI.Message,
+	})
+
+	if address := cacheServerAddress(m); address != "" {
+		add(corev1.EnvVar{
+			Name:  envCacheServer,
+			Value: address,
+		})
 	}
+
+	return envVars
+}
+
+// cacheServerAddress returns the PODINFO_CACHE_SERVER value for m. The managed
+// Redis Service takes precedence over an external cache server, and an empty
+// string means podinfo runs without a cache.
+func cacheServerAddress(m *appv1alpha1.MyAppResource) string {
+	switch {
+	case m.Spec.Redis.Enabled:
+		return fmt.Sprintf("tcp://%s:%d", redisName(m), redisPort)
+	case m.Spec.CacheServer.Enabled && m.Spec.CacheServer.Host != "":
+		port := m.Spec.CacheServer.Port
+		if port == 0 {
+			port = redisPort
+		}
+		return fmt.Sprintf("tcp://%s:%d", m.Spec.CacheServer.Host, port)
+	default:
+		return ""
+	}
+}
+
+func hasEnvVar(envVars []corev1.EnvVar, name string) bool {
+	for _, envVar := range envVars {
+		if envVar.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
 func labelsForPodinfo(name string) map[string]string {
 	return map[string]string{"app": "podinfo", "podinfo_cr": name}
 }
 
-func getPodNames(pods []corev1.Pod) []string {
-	var podNames []string
-	for _, pod := range pods {
-		podNames = append(podNames, pod.Name)
+// PodinfoSelector selects the objects labeled for the podinfo Deployment of
+// any MyAppResource, e.g. to restrict the cache of its ReplicaSets.
+func PodinfoSelector() labels.Selector {
+	return labels.SelectorFromSet(labels.Set{"app": "podinfo"})
+}
+
+// podinfoImage returns the podinfo image reference from spec.image, falling
+// back to the configured default image for any part that is not set.
+func podinfoImage(m *appv1alpha1.MyAppResource, defaults config.PodinfoDefaults) string {
+	repository := m.Spec.Image.Repository
+	if repository == "" {
+		repository = defaults.Repository
 	}
-	return podNames
+	tag := m.Spec.Image.Tag
+	if tag == "" {
+		tag = defaults.Tag
+	}
+	if strings.HasPrefix(tag, "sha256:") {
+		return repository + "@" + tag
+	}
+	return repository + ":" + tag
 }
 
-func (r *MyAppResourceReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&appv1alpha1.MyAppResource{}).
-		Owns(&appsv1.Deployment{}).
-		Complete(r)
+// resourceRequirements converts the CR resource spec into container resources.
+// The legacy memoryLimit and cpuRequest fields are used when the matching
+// entries of limits and requests are not set.
+func resourceRequirements(res appv1alpha1.ResourceRequirements) (corev1.ResourceRequirements, error) {
+	requests := res.Requests
+	if requests.CPU == "" {
+		requests.CPU = res.CPURequest
+	}
+	limits := res.Limits
+	if limits.Memory == "" {
+		limits.Memory = res.MemoryLimit
+	}
+
+	var err error
+	out := corev1.ResourceRequirements{}
+	if out.Requests, err = resourceList(requests); err != nil {
+		return out, fmt.Errorf("requests: %w", err)
+	}
+	if out.Limits, err = resourceList(limits); err != nil {
+		return out, fmt.Errorf("limits: %w", err)
+	}
+	return out, nil
 }
 
-func (r *MyAppResourceReconciler) deploymentForRedis(m *appv1alpha1.MyAppResource) *appsv1.Deployment {
-	labels := labelsForRedis(m.Name)
-	return &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      m.Name + "-redis",
-			Namespace: m.Namespace,
-			Labels:    labels,
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &m.Spec.ReplicaCount, // assuming Redis should have same replica count, adjust as needed
-			Selector: &metav1.LabelSelector{
-				MatchLabels: labels,
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
-				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:  "redis",
-							Image: "redis:latest",
-						},
-					},
-				},
-			},
-		},
+func resourceList(l appv1alpha1.ResourceList) (corev1.ResourceList, error) {
+	var out corev1.ResourceList
+	for name, value := range map[corev1.ResourceName]string{
+		corev1.ResourceCPU:              l.CPU,
+		corev1.ResourceMemory:           l.Memory,
+		corev1.ResourceEphemeralStorage: l.EphemeralStorage,
+	} {
+		if value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if out == nil {
+			out = corev1.ResourceList{}
+		}
+		out[name] = q
 	}
+	return out, nil
 }
 
-func labelsForRedis(name string) map[string]string {
-	return map[string]string{"app": "redis", "redis_cr": name}
+func (r *MyAppResourceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.Client = newTracingClient(r.Client, r.tracer())
+
+	// Index the ConfigMaps and Secrets referenced by each MyAppResource, so a
+	// change to them can be mapped back to the resources using them
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &appv1alpha1.MyAppResource{}, configMapIndexKey, func(obj client.Object) []string {
+		return referencedConfigMaps(obj.(*appv1alpha1.MyAppResource))
+	}); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &appv1alpha1.MyAppResource{}, secretIndexKey, func(obj client.Object) []string {
+		return referencedSecrets(obj.(*appv1alpha1.MyAppResource))
+	}); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.config().Controller.MaxConcurrentReconciles}).
+		For(&appv1alpha1.MyAppResource{}, builder.WithPredicates(r.shardPredicate())).
+		Owns(&appsv1.Deployment{}, builder.WithPredicates(r.ownerShardPredicate())).
+		Owns(&corev1.Service{}, builder.WithPredicates(r.ownerShardPredicate())).
+		Owns(&networkingv1.Ingress{}, builder.WithPredicates(r.ownerShardPredicate())).
+		// Only the metadata of ConfigMaps and Secrets is cached, the data is
+		// not needed to map a change to the resources referencing them
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.requestsForConfigMap), builder.OnlyMetadata).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.requestsForSecret), builder.OnlyMetadata).
+		Complete(r)
 }
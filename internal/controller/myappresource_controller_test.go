@@ -6,9 +6,14 @@ import (
 
 	"github.com/stretchr/testify/require"
 	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	"github.com/sumyann/k8s-controller/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestMyAppResourceReconciler_Reconcile(t *testing.T) {
@@ -27,9 +32,10 @@ func TestMyAppResourceReconciler_Reconcile(t *testing.T) {
 
 	// Create a new reconciler
 	r := &MyAppResourceReconciler{
-		Client: k8sClient,
-		Log:    ctrl.Log.WithName("controllers").WithName("MyAppResource"),
-		Scheme: scheme,
+		Client:   k8sClient,
+		Log:      ctrl.Log.WithName("controllers").WithName("MyAppResource"),
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(100),
 	}
 
 	// Call the Reconcile method
@@ -42,3 +48,188 @@ func TestMyAppResourceReconciler_Reconcile(t *testing.T) {
 	require.NoError(t, err)
 
 }
+
+func TestResourceRequirements(t *testing.T) {
+	res, err := resourceRequirements(appv1alpha1.ResourceRequirements{
+		MemoryLimit: "64Mi",
+		CPURequest:  "100m",
+		Requests:    appv1alpha1.ResourceList{Memory: "32Mi", EphemeralStorage: "1Gi"},
+		Limits:      appv1alpha1.ResourceList{CPU: "500m", Memory: "128Mi"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, corev1.ResourceList{
+		corev1.ResourceCPU:              resource.MustParse("100m"),
+		corev1.ResourceMemory:           resource.MustParse("32Mi"),
+		corev1.ResourceEphemeralStorage: resource.MustParse("1Gi"),
+	}, res.Requests)
+	require.Equal(t, corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("500m"),
+		corev1.ResourceMemory: resource.MustParse("128Mi"),
+	}, res.Limits)
+
+	_, err = resourceRequirements(appv1alpha1.ResourceRequirements{MemoryLimit: "64MB"})
+	require.Error(t, err)
+}
+
+func TestPodinfoImage(t *testing.T) {
+	defaults := config.Default().Defaults.Podinfo
+	m := &appv1alpha1.MyAppResource{}
+	require.Equal(t, "ghcr.io/stefanprodan/podinfo:latest", podinfoImage(m, defaults))
+
+	m.Spec.Image = appv1alpha1.Image{Repository: "example.com/podinfo", Tag: "6.5.0"}
+	require.Equal(t, "example.com/podinfo:6.5.0", podinfoImage(m, defaults))
+
+	m.Spec.Image.Tag = "sha256:abc"
+	require.Equal(t, "example.com/podinfo@sha256:abc", podinfoImage(m, defaults))
+
+	defaults.Repository = "registry.example.com/podinfo"
+	m.Spec.Image = appv1alpha1.Image{Tag: "6.5.0"}
+	require.Equal(t, "registry.example.com/podinfo:6.5.0", podinfoImage(m, defaults))
+}
+
+func TestCacheServerAddress(t *testing.T) {
+	m := &appv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: "example-app"}}
+	require.Empty(t, cacheServerAddress(m))
+
+	m.Spec.CacheServer = appv1alpha1.CServer{Enabled: true, Host: "cache.example.com"}
+	require.Equal(t, "tcp://cache.example.com:6379", cacheServerAddress(m))
+
+	m.Spec.Redis.Enabled = true
+	require.Equal(t, "tcp://example-app-redis:6379", cacheServerAddress(m))
+
+	m.Spec.Env = []corev1.EnvVar{{Name: envCacheServer, Value: "tcp://elsewhere:6379"}}
+	envVars := podinfoEnvVars(m)
+	require.Contains(t, envVars, corev1.EnvVar{Name: envCacheServer, Value: "tcp://example-app-redis:6379"})
+	require.NotContains(t, envVars, m.Spec.Env[0])
+}
+
+func TestDeploymentForPodinfo(t *testing.T) {
+	r := &MyAppResourceReconciler{Log: ctrl.Log.WithName("test")}
+	m := &appv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default", UID: "1234"}}
+	m.Spec.ReplicaCount = 2
+	m.Spec.Env = []corev1.EnvVar{{
+		Name: "POD_NAME",
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+		},
+	}}
+
+	deployment, err := r.deploymentForPodinfo(m)
+	require.NoError(t, err)
+	deployment.WithOwnerReferences(ownerReference(m))
+	require.Equal(t, "example-app-podinfo", *deployment.Name)
+	require.Equal(t, int32(2), *deployment.Spec.Replicas)
+
+	container := deployment.Spec.Template.Spec.Containers[0]
+	require.Equal(t, "ghcr.io/stefanprodan/podinfo:latest", *container.Image)
+	require.Equal(t, "POD_NAME", *container.Env[0].Name)
+	require.Nil(t, container.Env[0].Value)
+	require.Equal(t, "metadata.name", *container.Env[0].ValueFrom.FieldRef.FieldPath)
+	require.Equal(t, resource.MustParse("100m"), (*container.Resources.Requests)[corev1.ResourceCPU])
+
+	// The apply patch must only carry the fields we own
+	data, err := applyPatch{applyConfiguration: deployment}.Data(nil)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "null")
+	require.Contains(t, string(data), `"kind":"Deployment"`)
+	require.Contains(t, string(data), `"uid":"1234"`)
+}
+
+func TestDeploymentForPodinfoConfigDefaults(t *testing.T) {
+	c := config.Default()
+	c.Defaults.Podinfo.Repository = "registry.example.com/podinfo"
+	c.Defaults.Podinfo.Resources.Requests = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")}
+	r := &MyAppResourceReconciler{Log: ctrl.Log.WithName("test"), Config: config.NewStore("", c, ctrl.Log.WithName("test"))}
+	m := &appv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default"}}
+
+	deployment, err := r.deploymentForPodinfo(m)
+	require.NoError(t, err)
+	container := deployment.Spec.Template.Spec.Containers[0]
+	require.Equal(t, "registry.example.com/podinfo:latest", *container.Image)
+	require.Equal(t, resource.MustParse("50m"), (*container.Resources.Requests)[corev1.ResourceCPU])
+
+	// Resources set in the spec replace the defaults
+	m.Spec.Resources.Limits.Memory = "64Mi"
+	deployment, err = r.deploymentForPodinfo(m)
+	require.NoError(t, err)
+	container = deployment.Spec.Template.Spec.Containers[0]
+	require.Nil(t, container.Resources.Requests)
+	require.Equal(t, resource.MustParse("64Mi"), (*container.Resources.Limits)[corev1.ResourceMemory])
+}
+
+func TestServiceForPodinfo(t *testing.T) {
+	r := &MyAppResourceReconciler{}
+	m := &appv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default"}}
+
+	svc := r.serviceForPodinfo(m)
+	require.Equal(t, corev1.ServiceTypeClusterIP, *svc.Spec.Type)
+	require.Equal(t, labelsForPodinfo("example-app"), svc.Spec.Selector)
+	require.Equal(t, int32(9898), *svc.Spec.Ports[0].Port)
+	require.Nil(t, svc.Spec.Ports[0].NodePort)
+	require.Nil(t, svc.Annotations)
+
+	m.Spec.Service = appv1alpha1.Service{Type: corev1.ServiceTypeLoadBalancer, Port: 80, NodePort: 30098}
+	svc = r.serviceForPodinfo(m)
+	require.Equal(t, int32(30098), *svc.Spec.Ports[0].NodePort)
+}
+
+func TestServiceEndpoint(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-app-podinfo", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeClusterIP,
+			Ports: []corev1.ServicePort{{Port: 9898}},
+		},
+	}
+	require.Equal(t, "http://example-app-podinfo.default.svc:9898", serviceEndpoint(svc))
+
+	svc.Spec.Type = corev1.ServiceTypeLoadBalancer
+	svc.Spec.Ports[0].Port = 80
+	svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+	require.Equal(t, "http://10.0.0.1:80", serviceEndpoint(svc))
+}
+
+func TestIngressForPodinfo(t *testing.T) {
+	r := &MyAppResourceReconciler{}
+	m := &appv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default"}}
+	m.Spec.Ingress = appv1alpha1.Ingress{
+		Enabled:       true,
+		Hosts:         []string{"podinfo.example.com"},
+		TLSSecretName: "podinfo-tls",
+	}
+
+	ing := r.ingressForPodinfo(m)
+	require.Len(t, ing.Spec.Rules, 1)
+	require.Equal(t, "podinfo.example.com", *ing.Spec.Rules[0].Host)
+	require.Equal(t, "/", *ing.Spec.Rules[0].HTTP.Paths[0].Path)
+	require.Equal(t, "example-app-podinfo", *ing.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name)
+	require.Equal(t, "podinfo-tls", *ing.Spec.TLS[0].SecretName)
+	require.Equal(t, []string{"podinfo.example.com"}, ing.Spec.TLS[0].Hosts)
+	require.Nil(t, ing.Spec.IngressClassName)
+}
+
+func TestReconcileOutsideWatchedNamespaces(t *testing.T) {
+	ctx := context.Background()
+	m := &appv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(m).WithStatusSubresource(m).Build()
+	r := &MyAppResourceReconciler{
+		Client:          c,
+		Log:             ctrl.Log.WithName("test"),
+		Recorder:        record.NewFakeRecorder(10),
+		WatchNamespaces: []string{"production", "staging"},
+	}
+	require.True(t, r.watchesNamespace("staging"))
+	require.False(t, r.watchesNamespace("default"))
+
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: m.Name, Namespace: m.Namespace}})
+	require.NoError(t, err)
+	require.Equal(t, ctrl.Result{}, result)
+
+	// The resource is not touched, not even to add the finalizer
+	found := &appv1alpha1.MyAppResource{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: m.Name, Namespace: m.Namespace}, found))
+	require.Empty(t, found.Finalizers)
+
+	r.WatchNamespaces = nil
+	require.True(t, r.watchesNamespace("default"))
+}
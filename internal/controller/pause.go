@@ -0,0 +1,94 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+const (
+	// pausedAnnotation pauses a MyAppResource like spec.paused when set to "true".
+	pausedAnnotation = "my.api.group.my.api.group/paused"
+	// syncNowAnnotation requests a full reconcile whenever its value changes,
+	// even while the resource is paused. The handled value is recorded in
+	// status.lastHandledSyncNow.
+	syncNowAnnotation = "my.api.group.my.api.group/sync-now"
+)
+
+// pausedBy returns what pauses m, or an empty string when it is not paused.
+func pausedBy(m *appv1alpha1.MyAppResource) string {
+	switch {
+	case m.Spec.Paused:
+		return "spec.paused"
+	case m.Annotations[pausedAnnotation] == "true":
+		return "the " + pausedAnnotation + " annotation"
+	default:
+		return ""
+	}
+}
+
+// syncRequested reports whether the sync-now annotation of m holds a value
+// that has not been handled yet.
+func syncRequested(m *appv1alpha1.MyAppResource) bool {
+	value := m.Annotations[syncNowAnnotation]
+	return value != "" && value != m.Status.LastHandledSyncNow
+}
+
+// observeChildren reads the podinfo Deployment and Service without changing
+// them, for the status of a paused resource. Missing objects are returned
+// empty.
+func (r *MyAppResourceReconciler) observeChildren(ctx context.Context, m *appv1alpha1.MyAppResource) (*appsv1.Deployment, *corev1.Service, error) {
+	key := types.NamespacedName{Name: m.Name + "-podinfo", Namespace: m.Namespace}
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, key, deployment); err != nil && !errors.IsNotFound(err) {
+		return nil, nil, err
+	}
+	service := &corev1.Service{}
+	if err := r.Get(ctx, key, service); errors.IsNotFound(err) {
+		return deployment, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return deployment, service, nil
+}
+
+// setPausedCondition reports in the Paused condition whether the children of
+// m are left alone.
+func setPausedCondition(status *appv1alpha1.MyAppResourceStatus, m *appv1alpha1.MyAppResource) {
+	condition := metav1.Condition{
+		Type:               appv1alpha1.ConditionPaused,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Reconciling",
+		Message:            "Changes to the children are made",
+		ObservedGeneration: m.Generation,
+	}
+	if by := pausedBy(m); by != "" {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Paused"
+		condition.Message = "Changes to the children are paused by " + by
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+}
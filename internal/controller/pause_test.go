@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcilePausedAndSyncNow(t *testing.T) {
+	ctx := context.Background()
+	m := &appv1alpha1.MyAppResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default", UID: "uid", Generation: 1, Finalizers: []string{finalizerName}},
+		Spec: appv1alpha1.MyAppResourceSpec{
+			ReplicaCount: 2,
+			Image:        appv1alpha1.Image{Repository: "ghcr.io/stefanprodan/podinfo", Tag: "6.5.0"},
+			Paused:       true,
+		},
+	}
+	// A hotfix scaled the Deployment down by hand
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "example-app-podinfo", Namespace: "default"}}
+	deployment.Spec.Replicas = &replicas
+	deployment.Spec.Template.Spec.Containers = []corev1.Container{{Name: "podinfo", Image: "ghcr.io/stefanprodan/podinfo:6.5.0"}}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "example-app-podinfo", Namespace: "default"}}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(m, deployment, service).WithStatusSubresource(m).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &MyAppResourceReconciler{Client: c, Log: ctrl.Log.WithName("test"), Recorder: recorder}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: m.Name, Namespace: m.Namespace}}
+
+	reconcileAndGet := func() (*appv1alpha1.MyAppResource, *appsv1.Deployment) {
+		_, err := r.Reconcile(ctx, req)
+		require.NoError(t, err)
+		found := &appv1alpha1.MyAppResource{}
+		require.NoError(t, c.Get(ctx, req.NamespacedName, found))
+		d := &appsv1.Deployment{}
+		require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "example-app-podinfo", Namespace: "default"}, d))
+		return found, d
+	}
+
+	// Paused, the hotfix is kept
+	found, d := reconcileAndGet()
+	require.Equal(t, int32(1), *d.Spec.Replicas)
+	paused := meta.FindStatusCondition(found.Status.Conditions, appv1alpha1.ConditionPaused)
+	require.NotNil(t, paused)
+	require.Equal(t, metav1.ConditionTrue, paused.Status)
+	require.Equal(t, "Changes to the children are paused by spec.paused", paused.Message)
+	require.Zero(t, found.Status.ObservedGeneration)
+	require.Empty(t, recorder.Events)
+
+	// A sync-now request is carried out once, even while paused
+	found.Annotations = map[string]string{syncNowAnnotation: "2023-11-02T10:00:00Z"}
+	require.NoError(t, c.Update(ctx, found))
+	found, d = reconcileAndGet()
+	require.Equal(t, int32(2), *d.Spec.Replicas)
+	require.Equal(t, "2023-11-02T10:00:00Z", found.Status.LastHandledSyncNow)
+	require.Equal(t, int64(1), found.Status.ObservedGeneration)
+	events := drainEvents(recorder)
+	require.Contains(t, events, `Normal Synced Synced the children as requested by sync-now "2023-11-02T10:00:00Z"`)
+
+	// A spec changed while paused is not observed until it is applied
+	*d.Spec.Replicas = 1
+	require.NoError(t, c.Update(ctx, d))
+	found.Spec.ReplicaCount = 3
+	found.Generation = 2
+	require.NoError(t, c.Update(ctx, found))
+	found, d = reconcileAndGet()
+	require.Equal(t, int32(1), *d.Spec.Replicas)
+	require.Equal(t, int64(1), found.Status.ObservedGeneration)
+
+	// Resuming applies the spec and reverts the hotfix
+	found.Spec.Paused = false
+	found.Generation = 3
+	require.NoError(t, c.Update(ctx, found))
+	found, d = reconcileAndGet()
+	require.Equal(t, int32(3), *d.Spec.Replicas)
+	require.Equal(t, int64(3), found.Status.ObservedGeneration)
+	require.Equal(t, metav1.ConditionFalse, meta.FindStatusCondition(found.Status.Conditions, appv1alpha1.ConditionPaused).Status)
+	require.Contains(t, drainEvents(recorder), "Normal Updated Updated Deployment example-app-podinfo")
+}
+
+func TestPausedBy(t *testing.T) {
+	m := &appv1alpha1.MyAppResource{}
+	require.Equal(t, "", pausedBy(m))
+	m.Annotations = map[string]string{pausedAnnotation: "true"}
+	require.Equal(t, "the my.api.group.my.api.group/paused annotation", pausedBy(m))
+	m.Spec.Paused = true
+	require.Equal(t, "spec.paused", pausedBy(m))
+}
+
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
@@ -0,0 +1,333 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	"github.com/sumyann/k8s-controller/internal/config"
+)
+
+// dryRunAnnotation puts a single MyAppResource in dry-run mode when set to "true".
+const dryRunAnnotation = "my.api.group.my.api.group/dry-run"
+
+const (
+	// maxPlannedChanges bounds the size of status.plan.
+	maxPlannedChanges = 50
+	// maxPlannedValue is the length planned values are cut to.
+	maxPlannedValue = 256
+)
+
+// dryRun reports whether changes to the children of m are only planned.
+func (r *MyAppResourceReconciler) dryRun(m *appv1alpha1.MyAppResource) bool {
+	return r.DryRun || m.Annotations[dryRunAnnotation] == "true"
+}
+
+// plan computes the changes a reconcile would make to the children of m with
+// server-side dry-run requests, and records them in status.plan and as Events.
+// Nothing but the status of m is written.
+func (r *MyAppResourceReconciler) plan(ctx context.Context, m *appv1alpha1.MyAppResource) (err error) {
+	ctx, span := r.startSpan(ctx, "plan", m)
+	defer func() { endSpan(span, err) }()
+
+	log := r.logger(ctx).WithValues("myappresource", types.NamespacedName{Name: m.Name, Namespace: m.Namespace})
+	log.Info("Planning changes in dry-run mode")
+
+	var changes []appv1alpha1.PlannedChange
+	add := func(planned []appv1alpha1.PlannedChange, err error) error {
+		changes = append(changes, planned...)
+		return err
+	}
+
+	if m.Spec.Redis.Enabled {
+		redisDeployment, err := r.deploymentForRedis(m)
+		if err != nil {
+			r.markDegraded(ctx, m, "InvalidSpec", err)
+			return err
+		}
+		redisDeployment.WithOwnerReferences(ownerReference(m))
+		if err := add(r.planApply(ctx, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: *redisDeployment.Name, Namespace: m.Namespace}}, redisDeployment)); err != nil {
+			return err
+		}
+		redisService := r.serviceForRedis(m)
+		redisService.WithOwnerReferences(ownerReference(m))
+		if err := add(r.planApply(ctx, &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: *redisService.Name, Namespace: m.Namespace}}, redisService)); err != nil {
+			return err
+		}
+	} else {
+		if err := add(r.planDelete(ctx, m, &appsv1.Deployment{}, redisName(m))); err != nil {
+			return err
+		}
+		if err := add(r.planDelete(ctx, m, &corev1.Service{}, redisName(m))); err != nil {
+			return err
+		}
+	}
+
+	podinfoService := r.serviceForPodinfo(m)
+	podinfoService.WithOwnerReferences(ownerReference(m))
+	if err := add(r.planApply(ctx, &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: *podinfoService.Name, Namespace: m.Namespace}}, podinfoService)); err != nil {
+		return err
+	}
+
+	if m.Spec.Ingress.Enabled {
+		podinfoIngress := r.ingressForPodinfo(m)
+		podinfoIngress.WithOwnerReferences(ownerReference(m))
+		if err := add(r.planApply(ctx, &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: *podinfoIngress.Name, Namespace: m.Namespace}}, podinfoIngress)); err != nil {
+			return err
+		}
+	} else if err := add(r.planDelete(ctx, m, &networkingv1.Ingress{}, m.Name+"-podinfo")); err != nil {
+		return err
+	}
+
+	podinfoDeployment, err := r.deploymentForPodinfo(m)
+	if err != nil {
+		r.markDegraded(ctx, m, "InvalidSpec", err)
+		return err
+	}
+	podinfoDeployment.WithOwnerReferences(ownerReference(m))
+	if r.config().Enabled(config.ConfigChecksum) {
+		checksum, err := r.configChecksum(ctx, m)
+		if err != nil {
+			return err
+		}
+		if checksum != "" {
+			podinfoDeployment.Spec.Template.WithAnnotations(map[string]string{configChecksumAnnotation: checksum})
+		}
+	}
+	found := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: *podinfoDeployment.Name, Namespace: m.Namespace}}
+	live := &appsv1.Deployment{}
+	var managedEnv []string
+	if err := r.Get(ctx, client.ObjectKeyFromObject(found), live); err == nil {
+		managedEnv = managedEnvNames(live)
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+	keepStaleEnvVars(podinfoDeployment, live, managedEnv, podinfoEnvVars(m))
+	if err := add(r.planApply(ctx, found, podinfoDeployment)); err != nil {
+		return err
+	}
+	if stale := staleEnvVars(found, managedEnv, podinfoEnvVars(m)); len(stale) > 0 && r.config().Enabled(config.EnvPruning) {
+		for _, name := range stale {
+			changes = append(changes, appv1alpha1.PlannedChange{
+				Object: "Deployment/" + found.Name,
+				Action: appv1alpha1.PlannedUpdate,
+				Field:  "spec.template.spec.containers[name=podinfo].env[name=" + name + "]",
+				Live:   plannedValue(envVarValue(podinfoContainer(found).Env, name)),
+			})
+		}
+	}
+
+	return r.recordPlan(ctx, m, changes)
+}
+
+// planApply server-side applies ac in dry-run mode and returns the changes it
+// would make to the live object. obj receives the object as it would be
+// after the apply.
+func (r *MyAppResourceReconciler) planApply(ctx context.Context, obj client.Object, ac interface{}) ([]appv1alpha1.PlannedChange, error) {
+	live := obj.DeepCopyObject().(client.Object)
+	err := r.Get(ctx, client.ObjectKeyFromObject(obj), live)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	existed := err == nil
+
+	if err := r.Patch(ctx, obj, applyPatch{applyConfiguration: ac}, client.FieldOwner(fieldManager), client.ForceOwnership, client.DryRunAll); err != nil {
+		return nil, err
+	}
+
+	object := objectKindName(obj)
+	if !existed {
+		return []appv1alpha1.PlannedChange{{Object: object, Action: appv1alpha1.PlannedCreate}}, nil
+	}
+	var changes []appv1alpha1.PlannedChange
+	diffFields("", desiredState(live), desiredState(obj), func(field string, liveValue, desiredValue interface{}) {
+		changes = append(changes, appv1alpha1.PlannedChange{
+			Object:  object,
+			Action:  appv1alpha1.PlannedUpdate,
+			Field:   field,
+			Live:    plannedValue(liveValue),
+			Desired: plannedValue(desiredValue),
+		})
+	})
+	return changes, nil
+}
+
+// planDelete returns the deletion of the named object when it exists and is
+// controlled by m, like deleteOwned would do.
+func (r *MyAppResourceReconciler) planDelete(ctx context.Context, m *appv1alpha1.MyAppResource, obj client.Object, name string) ([]appv1alpha1.PlannedChange, error) {
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: m.Namespace}, obj)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !metav1.IsControlledBy(obj, m) {
+		return nil, nil
+	}
+	return []appv1alpha1.PlannedChange{{Object: objectKindName(obj), Action: appv1alpha1.PlannedDelete}}, nil
+}
+
+// recordPlan writes changes to status.plan, and records an Event per child
+// when the plan is different from the recorded one.
+func (r *MyAppResourceReconciler) recordPlan(ctx context.Context, m *appv1alpha1.MyAppResource, changes []appv1alpha1.PlannedChange) error {
+	plan := &appv1alpha1.PlanStatus{ObservedGeneration: m.Generation, Changes: changes}
+	if len(changes) > maxPlannedChanges {
+		plan.Changes = changes[:maxPlannedChanges]
+		plan.Truncated = true
+	}
+	if equality.Semantic.DeepEqual(m.Status.Plan, plan) {
+		return nil
+	}
+
+	if len(changes) == 0 {
+		r.Recorder.Event(m, corev1.EventTypeNormal, reasonDryRun, "No changes planned, the children match the spec")
+	}
+	for _, summary := range summarizePlan(changes) {
+		r.Recorder.Event(m, corev1.EventTypeNormal, reasonDryRun, summary)
+	}
+
+	m.Status.Plan = plan
+	return r.Status().Update(ctx, m)
+}
+
+// summarizePlan returns a line per child object of changes, in order.
+func summarizePlan(changes []appv1alpha1.PlannedChange) []string {
+	var (
+		objects []string
+		fields  = map[string][]string{}
+		actions = map[string]appv1alpha1.PlannedAction{}
+	)
+	for _, change := range changes {
+		if _, ok := actions[change.Object]; !ok {
+			objects = append(objects, change.Object)
+		}
+		actions[change.Object] = change.Action
+		if change.Field != "" {
+			fields[change.Object] = append(fields[change.Object], change.Field)
+		}
+	}
+
+	summaries := make([]string, 0, len(objects))
+	for _, object := range objects {
+		switch actions[object] {
+		case appv1alpha1.PlannedCreate:
+			summaries = append(summaries, "Would create "+object)
+		case appv1alpha1.PlannedDelete:
+			summaries = append(summaries, "Would delete "+object+", it is disabled in the spec")
+		default:
+			summaries = append(summaries, fmt.Sprintf("Would update %s: %s", object, strings.Join(fields[object], ", ")))
+		}
+	}
+	return summaries
+}
+
+// diffFields calls changed for every field that differs between live and
+// desired. Maps are compared key by key and lists of the same length element
+// by element, anything else is compared as a whole.
+func diffFields(path string, live, desired interface{}, changed func(field string, live, desired interface{})) {
+	liveMap, liveIsMap := live.(map[string]interface{})
+	desiredMap, desiredIsMap := desired.(map[string]interface{})
+	if liveIsMap && desiredIsMap {
+		keys := make([]string, 0, len(liveMap)+len(desiredMap))
+		for key := range liveMap {
+			keys = append(keys, key)
+		}
+		for key := range desiredMap {
+			if _, ok := liveMap[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			diffFields(field, liveMap[key], desiredMap[key], changed)
+		}
+		return
+	}
+
+	liveList, liveIsList := live.([]interface{})
+	desiredList, desiredIsList := desired.([]interface{})
+	if liveIsList && desiredIsList && len(liveList) == len(desiredList) {
+		for i := range liveList {
+			diffFields(fmt.Sprintf("%s[%d]", path, i), liveList[i], desiredList[i], changed)
+		}
+		return
+	}
+
+	if !equality.Semantic.DeepEqual(live, desired) {
+		changed(path, live, desired)
+	}
+}
+
+// plannedValue formats a field value for status.plan.
+func plannedValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		s = v
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		s = string(data)
+	}
+	if len(s) > maxPlannedValue {
+		// Cut at the start of a rune, so the value stays valid UTF-8
+		end := maxPlannedValue
+		for end > 0 && !utf8.RuneStart(s[end]) {
+			end--
+		}
+		s = s[:end] + "..."
+	}
+	return s
+}
+
+func envVarValue(envVars []corev1.EnvVar, name string) interface{} {
+	for _, envVar := range envVars {
+		if envVar.Name == name {
+			return envVar.Value
+		}
+	}
+	return nil
+}
+
+// objectKindName returns Kind/name of a typed child object.
+func objectKindName(obj client.Object) string {
+	return reflect.TypeOf(obj).Elem().Name() + "/" + obj.GetName()
+}
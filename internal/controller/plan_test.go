@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// dryRunClient returns a fake client holding objs, on which dry-run patches
+// return the patched object like the API server does, without storing it.
+func dryRunClient(objs ...client.Object) client.Client {
+	shadow := func() client.Client {
+		copies := make([]client.Object, 0, len(objs))
+		for _, obj := range objs {
+			copies = append(copies, obj.DeepCopyObject().(client.Object))
+		}
+		return fake.NewClientBuilder().WithScheme(scheme).WithObjects(copies...).Build()
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithStatusSubresource(&appv1alpha1.MyAppResource{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				patchOpts := &client.PatchOptions{}
+				patchOpts.ApplyOptions(opts)
+				if len(patchOpts.DryRun) == 0 {
+					return c.Patch(ctx, obj, patch, opts...)
+				}
+				// The fake client does not create objects on apply
+				dryRun := shadow()
+				err := dryRun.Patch(ctx, obj, patch, client.FieldOwner(fieldManager), client.ForceOwnership)
+				if !errors.IsNotFound(err) {
+					return err
+				}
+				data, err := patch.Data(obj)
+				if err != nil {
+					return err
+				}
+				if err := json.Unmarshal(data, obj); err != nil {
+					return err
+				}
+				return dryRun.Create(ctx, obj)
+			},
+			Create: func(context.Context, client.WithWatch, client.Object, ...client.CreateOption) error {
+				panic("unexpected create in dry-run mode")
+			},
+			Delete: func(context.Context, client.WithWatch, client.Object, ...client.DeleteOption) error {
+				panic("unexpected delete in dry-run mode")
+			},
+		}).Build()
+}
+
+func TestReconcileDryRun(t *testing.T) {
+	ctx := context.Background()
+	m := &appv1alpha1.MyAppResource{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "example-app",
+			Namespace:   "default",
+			UID:         "uid",
+			Annotations: map[string]string{dryRunAnnotation: "true"},
+		},
+		Spec: appv1alpha1.MyAppResourceSpec{
+			ReplicaCount: 2,
+			Image:        appv1alpha1.Image{Repository: "ghcr.io/stefanprodan/podinfo", Tag: "6.5.0"},
+		},
+	}
+	replicas := int32(1)
+	live := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "example-app-podinfo", Namespace: "default"}}
+	live.Spec.Replicas = &replicas
+	live.Spec.Selector = &metav1.LabelSelector{MatchLabels: labelsForPodinfo(m.Name)}
+	live.Spec.Template.Labels = labelsForPodinfo(m.Name)
+	live.Spec.Template.Spec.Containers = []corev1.Container{{Name: "podinfo", Image: "ghcr.io/stefanprodan/podinfo:6.4.0"}}
+	redis := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "example-app-redis", Namespace: "default"}}
+	isController := true
+	redis.OwnerReferences = []metav1.OwnerReference{{APIVersion: appv1alpha1.GroupVersion.String(), Kind: "MyAppResource", Name: m.Name, UID: m.UID, Controller: &isController}}
+
+	c := dryRunClient(m, live, redis)
+	recorder := record.NewFakeRecorder(10)
+	r := &MyAppResourceReconciler{Client: c, Log: ctrl.Log.WithName("test"), Recorder: recorder}
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: m.Name, Namespace: m.Namespace}}
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+
+	found := &appv1alpha1.MyAppResource{}
+	require.NoError(t, c.Get(ctx, req.NamespacedName, found))
+	require.Empty(t, found.Finalizers)
+	require.NotNil(t, found.Status.Plan)
+	changes := map[string]appv1alpha1.PlannedChange{}
+	for _, change := range found.Status.Plan.Changes {
+		changes[change.Object+" "+change.Field] = change
+	}
+	require.Equal(t, appv1alpha1.PlannedDelete, changes["Deployment/example-app-redis "].Action)
+	require.Equal(t, appv1alpha1.PlannedCreate, changes["Service/example-app-podinfo "].Action)
+	require.Equal(t, appv1alpha1.PlannedChange{
+		Object:  "Deployment/example-app-podinfo",
+		Action:  appv1alpha1.PlannedUpdate,
+		Field:   "spec.replicas",
+		Live:    "1",
+		Desired: "2",
+	}, changes["Deployment/example-app-podinfo spec.replicas"])
+	require.Equal(t, "ghcr.io/stefanprodan/podinfo:6.5.0", changes["Deployment/example-app-podinfo spec.template.spec.containers[0].image"].Desired)
+
+	require.Equal(t, "Normal DryRun Would delete Deployment/example-app-redis, it is disabled in the spec", <-recorder.Events)
+	require.Equal(t, "Normal DryRun Would create Service/example-app-podinfo", <-recorder.Events)
+	require.Contains(t, <-recorder.Events, "Normal DryRun Would update Deployment/example-app-podinfo: ")
+
+	// Nothing has been changed
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "example-app-podinfo", Namespace: "default"}, deployment))
+	require.Equal(t, int32(1), *deployment.Spec.Replicas)
+	require.True(t, errors.IsNotFound(c.Get(ctx, types.NamespacedName{Name: "example-app-podinfo", Namespace: "default"}, &corev1.Service{})))
+
+	// An unchanged plan is not recorded again
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.Empty(t, recorder.Events)
+}
+
+func TestReconcileDryRunDeletion(t *testing.T) {
+	ctx := context.Background()
+	newDeleted := func(policy appv1alpha1.DeletionPolicy) *appv1alpha1.MyAppResource {
+		now := metav1.Now()
+		return &appv1alpha1.MyAppResource{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "example-app",
+				Namespace:         "default",
+				Annotations:       map[string]string{dryRunAnnotation: "true"},
+				Finalizers:        []string{finalizerName},
+				DeletionTimestamp: &now,
+			},
+			Spec: appv1alpha1.MyAppResourceSpec{Deletion: appv1alpha1.Deletion{Policy: policy, SnapshotClaimName: "redis-snapshots"}},
+		}
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "example-app", Namespace: "default"}}
+
+	// Cascade only removes the finalizer, so the resource goes away
+	c := dryRunClient(newDeleted(""))
+	r := &MyAppResourceReconciler{Client: c, Log: ctrl.Log.WithName("test"), Recorder: record.NewFakeRecorder(10)}
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.True(t, errors.IsNotFound(c.Get(ctx, req.NamespacedName, &appv1alpha1.MyAppResource{})))
+
+	// Other policies are reported and keep the finalizer
+	c = dryRunClient(newDeleted(appv1alpha1.DeletionSnapshot))
+	recorder := record.NewFakeRecorder(10)
+	r = &MyAppResourceReconciler{Client: c, Log: ctrl.Log.WithName("test"), Recorder: recorder}
+	for i := 0; i < 2; i++ {
+		_, err = r.Reconcile(ctx, req)
+		require.NoError(t, err)
+	}
+	found := &appv1alpha1.MyAppResource{}
+	require.NoError(t, c.Get(ctx, req.NamespacedName, found))
+	require.Equal(t, []string{finalizerName}, found.Finalizers)
+	require.Equal(t, appv1alpha1.DeletionSnapshot, found.Status.Deletion.Policy)
+	require.Equal(t, appv1alpha1.DeletionPending, found.Status.Deletion.Phase)
+	require.Equal(t, []string{"Normal DryRun Would carry out the Snapshot deletion policy, the finalizer is kept until dry-run mode is turned off"}, drainEvents(recorder))
+}
+
+func TestDiffFields(t *testing.T) {
+	live := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": int64(1),
+			"ports":    []interface{}{map[string]interface{}{"port": int64(80)}},
+			"removed":  "x",
+		},
+	}
+	desired := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": int64(2),
+			"ports":    []interface{}{map[string]interface{}{"port": int64(8080)}},
+			"hosts":    []interface{}{"a", "b"},
+		},
+	}
+	var changes []string
+	diffFields("", live, desired, func(field string, live, desired interface{}) {
+		changes = append(changes, field+": "+plannedValue(live)+" -> "+plannedValue(desired))
+	})
+	require.Equal(t, []string{
+		`spec.hosts:  -> ["a","b"]`,
+		"spec.ports[0].port: 80 -> 8080",
+		"spec.removed: x -> ",
+		"spec.replicas: 1 -> 2",
+	}, changes)
+}
+
+func TestPlannedValue(t *testing.T) {
+	require.Equal(t, "", plannedValue(nil))
+	require.Equal(t, "2", plannedValue(int64(2)))
+	require.Equal(t, `["a","b"]`, plannedValue([]interface{}{"a", "b"}))
+
+	// Long values are cut without splitting a multi-byte character
+	long := "a" + strings.Repeat("é", maxPlannedValue)
+	value := plannedValue(long)
+	require.True(t, utf8.ValidString(value))
+	require.Equal(t, "a"+strings.Repeat("é", (maxPlannedValue-1)/2)+"...", value)
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	metav1ac "k8s.io/client-go/applyconfigurations/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+const redisPort = appv1alpha1.DefaultRedisPort
+
+// reconcileRedis makes sure the Redis Deployment and Service exist when
+// spec.redis.enabled is true, and removes them when it is false.
+func (r *MyAppResourceReconciler) reconcileRedis(ctx context.Context, m *appv1alpha1.MyAppResource) (err error) {
+	ctx, span := r.startSpan(ctx, "redis", m)
+	defer func() { endSpan(span, err) }()
+
+	if !m.Spec.Redis.Enabled {
+		if err := r.deleteOwned(ctx, m, &appsv1.Deployment{}, redisName(m)); err != nil {
+			return err
+		}
+		return r.deleteOwned(ctx, m, &corev1.Service{}, redisName(m))
+	}
+
+	log := r.logger(ctx).WithValues("myappresource", types.NamespacedName{Name: m.Name, Namespace: m.Namespace})
+
+	redisDeployment, err := r.deploymentForRedis(m)
+	if err != nil {
+		return err
+	}
+	redisDeployment.WithOwnerReferences(ownerReference(m))
+
+	// Remember the restart annotation, a restart is not reported as an update
+	liveDeployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: *redisDeployment.Name, Namespace: m.Namespace}, liveDeployment); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	log.Info("Applying Redis Deployment", "Deployment.Namespace", m.Namespace, "Deployment.Name", *redisDeployment.Name)
+	foundDeployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: *redisDeployment.Name, Namespace: m.Namespace}}
+	result, err := r.apply(ctx, foundDeployment, redisDeployment)
+	if err != nil {
+		return err
+	}
+	if !(result == applyUpdated && restarted(liveDeployment, foundDeployment)) {
+		r.recordApply(m, "Deployment", foundDeployment, result)
+	}
+
+	// The ClusterIP is not part of the applied configuration, so the
+	// allocated address is kept
+	redisService := r.serviceForRedis(m)
+	redisService.WithOwnerReferences(ownerReference(m))
+
+	log.Info("Applying Redis Service", "Service.Namespace", m.Namespace, "Service.Name", *redisService.Name)
+	foundService := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: *redisService.Name, Namespace: m.Namespace}}
+	result, err = r.apply(ctx, foundService, redisService)
+	if err != nil {
+		return err
+	}
+	r.recordApply(m, "Service", foundService, result)
+	return nil
+}
+
+// deleteOwned deletes the named object if it exists and is controlled by m.
+// Objects with the same name that belong to someone else are left alone.
+func (r *MyAppResourceReconciler) deleteOwned(ctx context.Context, m *appv1alpha1.MyAppResource, obj client.Object, name string) error {
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: m.Namespace}, obj)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(obj, m) {
+		return nil
+	}
+
+	r.logger(ctx).Info("Deleting disabled object", "Namespace", obj.GetNamespace(), "Name", obj.GetName())
+	if err := r.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	r.Recorder.Eventf(m, corev1.EventTypeNormal, reasonRemoved, "Deleted %s %s, it is disabled in the spec", reflect.TypeOf(obj).Elem().Name(), obj.GetName())
+	return nil
+}
+
+func (r *MyAppResourceReconciler) deploymentForRedis(m *appv1alpha1.MyAppResource) (*appsv1ac.DeploymentApplyConfiguration, error) {
+	labels := labelsForRedis(m.Name)
+
+	defaults := r.config().Defaults.Redis
+	resources, err := resourceRequirements(m.Spec.Redis.Resources)
+	if err != nil {
+		return nil, fmt.Errorf("spec.redis.resources: %w", err)
+	}
+	if len(resources.Requests) == 0 && len(resources.Limits) == 0 {
+		resources = *defaults.Resources.DeepCopy()
+	}
+
+	deployment := appsv1ac.Deployment(redisName(m), m.Namespace).
+		WithLabels(labels).
+		WithSpec(appsv1ac.DeploymentSpec().
+			WithReplicas(redisReplicas(m)).
+			WithSelector(metav1ac.LabelSelector().
+				WithMatchLabels(labels)).
+			WithTemplate(corev1ac.PodTemplateSpec().
+				WithLabels(labels).
+				WithSpec(corev1ac.PodSpec().
+					WithContainers(corev1ac.Container().
+						WithName("redis").
+						WithImage(defaults.Image).
+						WithPorts(corev1ac.ContainerPort().
+							WithName("redis").
+							WithContainerPort(redisPort).
+							WithProtocol(corev1.ProtocolTCP)).
+						WithResources(resourceRequirementsApplyConfiguration(resources))))))
+	stampRestart(deployment.Spec.Template, m)
+	return deployment, nil
+}
+
+func (r *MyAppResourceReconciler) serviceForRedis(m *appv1alpha1.MyAppResource) *corev1ac.ServiceApplyConfiguration {
+	labels := labelsForRedis(m.Name)
+	return corev1ac.Service(redisName(m), m.Namespace).
+		WithLabels(labels).
+		WithSpec(corev1ac.ServiceSpec().
+			WithSelector(labels).
+			WithPorts(corev1ac.ServicePort().
+				WithName("redis").
+				WithProtocol(corev1.ProtocolTCP).
+				WithPort(redisPort).
+				WithTargetPort(intstr.FromInt(redisPort))))
+}
+
+// redisReplicas returns the Redis replica count, defaulting to a single replica.
+// Redis runs without replication, so it is capped at one replica for objects
+// that were not validated by the webhook.
+func redisReplicas(m *appv1alpha1.MyAppResource) int32 {
+	if m.Spec.Redis.ReplicaCount != nil && *m.Spec.Redis.ReplicaCount < 1 {
+		return *m.Spec.Redis.ReplicaCount
+	}
+	return 1
+}
+
+// redisName is the name shared by the Redis Deployment and Service.
+func redisName(m *appv1alpha1.MyAppResource) string {
+	return m.Name + "-redis"
+}
+
+func labelsForRedis(name string) map[string]string {
+	return map[string]string{"app": "redis", "redis_cr": name}
+}
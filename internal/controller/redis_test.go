@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// applyClient returns a fake client holding objs, on which apply patches
+// create missing objects like the API server does.
+func applyClient(objs ...client.Object) client.Client {
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				err := c.Patch(ctx, obj, patch, opts...)
+				if !errors.IsNotFound(err) {
+					return err
+				}
+				data, err := patch.Data(obj)
+				if err != nil {
+					return err
+				}
+				if err := json.Unmarshal(data, obj); err != nil {
+					return err
+				}
+				return c.Create(ctx, obj)
+			},
+		}).Build()
+}
+
+func TestReconcileRedis(t *testing.T) {
+	ctx := context.Background()
+	m := &appv1alpha1.MyAppResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default", UID: "uid"},
+		Spec:       appv1alpha1.MyAppResourceSpec{Redis: appv1alpha1.Redis{Enabled: true}},
+	}
+	c := applyClient()
+	recorder := record.NewFakeRecorder(10)
+	r := &MyAppResourceReconciler{Client: c, Log: ctrl.Log.WithName("test"), Recorder: recorder}
+	key := types.NamespacedName{Name: "example-app-redis", Namespace: "default"}
+
+	// Enabled, the Deployment and Service are applied
+	require.NoError(t, r.reconcileRedis(ctx, m))
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, c.Get(ctx, key, deployment))
+	require.True(t, metav1.IsControlledBy(deployment, m))
+	require.Equal(t, int32(1), *deployment.Spec.Replicas)
+	container := deployment.Spec.Template.Spec.Containers[0]
+	require.Equal(t, "redis:latest", container.Image)
+	require.Equal(t, resource.MustParse("128Mi"), container.Resources.Limits[corev1.ResourceMemory])
+	service := &corev1.Service{}
+	require.NoError(t, c.Get(ctx, key, service))
+	require.True(t, metav1.IsControlledBy(service, m))
+	require.Equal(t, labelsForRedis(m.Name), service.Spec.Selector)
+	require.Equal(t, int32(redisPort), service.Spec.Ports[0].Port)
+	require.Equal(t, []string{
+		"Normal Created Created Deployment example-app-redis",
+		"Normal Created Created Service example-app-redis",
+	}, drainEvents(recorder))
+
+	// Disabled, they are deleted
+	m.Spec.Redis.Enabled = false
+	require.NoError(t, r.reconcileRedis(ctx, m))
+	require.True(t, errors.IsNotFound(c.Get(ctx, key, &appsv1.Deployment{})))
+	require.True(t, errors.IsNotFound(c.Get(ctx, key, &corev1.Service{})))
+	require.Equal(t, []string{
+		"Normal Removed Deleted Deployment example-app-redis, it is disabled in the spec",
+		"Normal Removed Deleted Service example-app-redis, it is disabled in the spec",
+	}, drainEvents(recorder))
+}
+
+func TestReconcileRedisKeepsObjectsOfOthers(t *testing.T) {
+	ctx := context.Background()
+	m := &appv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default", UID: "uid"}}
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "example-app-redis", Namespace: "default"}}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "example-app-redis", Namespace: "default"}}
+	c := applyClient(deployment, service)
+	recorder := record.NewFakeRecorder(10)
+	r := &MyAppResourceReconciler{Client: c, Log: ctrl.Log.WithName("test"), Recorder: recorder}
+
+	require.NoError(t, r.reconcileRedis(ctx, m))
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(deployment), &appsv1.Deployment{}))
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(service), &corev1.Service{}))
+	require.Empty(t, recorder.Events)
+}
+
+func TestRedisReplicas(t *testing.T) {
+	m := &appv1alpha1.MyAppResource{}
+	require.Equal(t, int32(1), redisReplicas(m))
+
+	for replicas, want := range map[int32]int32{0: 0, 1: 1, 3: 1} {
+		replicas := replicas
+		m.Spec.Redis.ReplicaCount = &replicas
+		require.Equal(t, want, redisReplicas(m), "replicaCount %d", replicas)
+	}
+}
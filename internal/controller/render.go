@@ -0,0 +1,48 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+// Render returns the apply configurations of the children of m, in the order
+// a reconcile applies them, without talking to a cluster. Owner references and
+// the config checksum depend on live objects and are left out. Disabled
+// children, which a reconcile deletes, are not part of the result.
+func (r *MyAppResourceReconciler) Render(m *appv1alpha1.MyAppResource) ([]interface{}, error) {
+	var objects []interface{}
+
+	if m.Spec.Redis.Enabled {
+		redisDeployment, err := r.deploymentForRedis(m)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, redisDeployment, r.serviceForRedis(m))
+	}
+
+	objects = append(objects, r.serviceForPodinfo(m))
+	if m.Spec.Ingress.Enabled {
+		objects = append(objects, r.ingressForPodinfo(m))
+	}
+
+	podinfoDeployment, err := r.deploymentForPodinfo(m)
+	if err != nil {
+		return nil, err
+	}
+	return append(objects, podinfoDeployment), nil
+}
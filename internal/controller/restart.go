@@ -0,0 +1,125 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+// restartedAtAnnotation is stamped on the pod templates of podinfo and Redis
+// with status.lastRestartTime. Changing it rolls the pods, like the annotation
+// set by kubectl rollout restart.
+const restartedAtAnnotation = "my.api.group.my.api.group/restarted-at"
+
+// now returns the current time of the reconciler's clock.
+func (r *MyAppResourceReconciler) now() time.Time {
+	if r.Clock != nil {
+		return r.Clock.Now()
+	}
+	return time.Now()
+}
+
+// scheduleRestart records a restart in status.lastRestartTime when
+// spec.restartAt has passed or spec.restartSchedule is due, before the pod
+// templates are stamped with it. It returns how long to wait until the next
+// restart, or zero when none is pending.
+func (r *MyAppResourceReconciler) scheduleRestart(ctx context.Context, m *appv1alpha1.MyAppResource) (time.Duration, error) {
+	now := r.now().UTC().Truncate(time.Second)
+	var last time.Time
+	if m.Status.LastRestartTime != nil {
+		last = m.Status.LastRestartTime.Time
+	}
+
+	var (
+		restart time.Time
+		reason  string
+		next    time.Time
+	)
+	if at := m.Spec.RestartAt; at != nil && at.Time.After(last) {
+		if at.Time.After(now) {
+			next = at.Time
+		} else {
+			restart, reason = at.Time, "spec.restartAt"
+		}
+	}
+	if m.Spec.RestartSchedule != "" {
+		schedule, err := cron.ParseStandard(m.Spec.RestartSchedule)
+		if err != nil {
+			return 0, fmt.Errorf("spec.restartSchedule: %w", err)
+		}
+		// The schedule starts with the resource, missed runs are caught up
+		// with a single restart
+		from := last
+		if from.IsZero() {
+			from = m.CreationTimestamp.Time
+		}
+		if due := schedule.Next(from); !due.After(now) {
+			restart, reason = now, "spec.restartSchedule"
+			next = earliest(next, schedule.Next(now))
+		} else {
+			next = earliest(next, due)
+		}
+	}
+
+	if !restart.IsZero() {
+		m.Status.LastRestartTime = &metav1.Time{Time: restart}
+		if err := r.Status().Update(ctx, m); err != nil {
+			return 0, err
+		}
+		r.Recorder.Eventf(m, corev1.EventTypeNormal, reasonRestarted, "Restarting the pods as requested by %s", reason)
+	}
+	if next.IsZero() {
+		return 0, nil
+	}
+	if wait := next.Sub(r.now()); wait > time.Second {
+		return wait, nil
+	}
+	return time.Second, nil
+}
+
+// stampRestart sets the restart annotation on a pod template of m once a
+// restart has been requested.
+func stampRestart(template *corev1ac.PodTemplateSpecApplyConfiguration, m *appv1alpha1.MyAppResource) {
+	if m.Status.LastRestartTime != nil {
+		template.WithAnnotations(map[string]string{restartedAtAnnotation: m.Status.LastRestartTime.UTC().Format(time.RFC3339)})
+	}
+}
+
+// restarted reports whether an apply changed the restart annotation of a
+// Deployment. The restart has been recorded by scheduleRestart already, so it
+// is not reported as an update.
+func restarted(before, after *appsv1.Deployment) bool {
+	return before.Spec.Template.Annotations[restartedAtAnnotation] != after.Spec.Template.Annotations[restartedAtAnnotation]
+}
+
+// earliest returns the earlier of two times, ignoring zero times.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestScheduleRestart(t *testing.T) {
+	now := time.Date(2023, 11, 2, 5, 0, 0, 0, time.UTC)
+	at := func(hour, minute int) *metav1.Time {
+		t := metav1.NewTime(time.Date(2023, 11, 2, hour, minute, 0, 0, time.UTC))
+		return &t
+	}
+
+	for name, tc := range map[string]struct {
+		restartAt       *metav1.Time
+		schedule        string
+		lastRestart     *metav1.Time
+		wantLastRestart *metav1.Time
+		wantWait        time.Duration
+	}{
+		"nothing requested": {},
+		"restartAt ahead": {
+			restartAt: at(6, 30),
+			wantWait:  90 * time.Minute,
+		},
+		"restartAt passed": {
+			restartAt:       at(4, 30),
+			wantLastRestart: at(4, 30),
+		},
+		"restartAt already handled": {
+			restartAt:       at(4, 30),
+			lastRestart:     at(4, 30),
+			wantLastRestart: at(4, 30),
+		},
+		"schedule due": {
+			schedule:        "0 4 * * *",
+			wantLastRestart: at(5, 0),
+			wantWait:        23 * time.Hour,
+		},
+		"schedule handled": {
+			schedule:        "0 4 * * *",
+			lastRestart:     at(4, 0),
+			wantLastRestart: at(4, 0),
+			wantWait:        23 * time.Hour,
+		},
+		"schedule in another time zone": {
+			schedule: "CRON_TZ=Europe/Berlin 0 7 * * *",
+			// 06:00 UTC is 07:00 in Berlin
+			lastRestart:     at(4, 0),
+			wantLastRestart: at(4, 0),
+			wantWait:        time.Hour,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := &appv1alpha1.MyAppResource{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "example-app",
+					Namespace:         "default",
+					CreationTimestamp: metav1.NewTime(now.Add(-24 * time.Hour)),
+				},
+				Spec:   appv1alpha1.MyAppResourceSpec{RestartAt: tc.restartAt, RestartSchedule: tc.schedule},
+				Status: appv1alpha1.MyAppResourceStatus{LastRestartTime: tc.lastRestart},
+			}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(m).WithStatusSubresource(m).Build()
+			recorder := record.NewFakeRecorder(10)
+			r := &MyAppResourceReconciler{Client: c, Log: ctrl.Log.WithName("test"), Recorder: recorder, Clock: clocktesting.NewFakePassiveClock(now)}
+
+			wait, err := r.scheduleRestart(context.Background(), m)
+			require.NoError(t, err)
+			require.Equal(t, tc.wantWait, wait)
+
+			found := &appv1alpha1.MyAppResource{}
+			require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: m.Name, Namespace: m.Namespace}, found))
+			if tc.wantLastRestart == nil {
+				require.Nil(t, found.Status.LastRestartTime)
+			} else {
+				require.True(t, tc.wantLastRestart.Equal(found.Status.LastRestartTime), found.Status.LastRestartTime)
+			}
+			if !tc.wantLastRestart.Equal(tc.lastRestart) {
+				require.Contains(t, <-recorder.Events, "Normal Restarted Restarting the pods as requested by spec.restart")
+			}
+			require.Empty(t, recorder.Events)
+		})
+	}
+}
+
+func TestDeploymentsStampRestart(t *testing.T) {
+	m := &appv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default"}}
+	r := &MyAppResourceReconciler{Log: ctrl.Log.WithName("test")}
+
+	podinfo, err := r.deploymentForPodinfo(m)
+	require.NoError(t, err)
+	require.NotContains(t, podinfo.Spec.Template.Annotations, restartedAtAnnotation)
+
+	restartedAt := metav1.NewTime(time.Date(2023, 11, 2, 5, 0, 0, 0, time.UTC))
+	m.Status.LastRestartTime = &restartedAt
+	podinfo, err = r.deploymentForPodinfo(m)
+	require.NoError(t, err)
+	require.Equal(t, "2023-11-02T05:00:00Z", podinfo.Spec.Template.Annotations[restartedAtAnnotation])
+	redis, err := r.deploymentForRedis(m)
+	require.NoError(t, err)
+	require.Equal(t, "2023-11-02T05:00:00Z", redis.Spec.Template.Annotations[restartedAtAnnotation])
+}
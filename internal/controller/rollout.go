@@ -0,0 +1,181 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+const (
+	// revisionAnnotation is set by the Deployment controller on a Deployment
+	// and on its ReplicaSets, the newest ReplicaSet has the Deployment's revision.
+	revisionAnnotation = "deployment.kubernetes.io/revision"
+
+	// Reasons of the Progressing condition of a Deployment
+	reasonNewReplicaSetAvailable   = "NewReplicaSetAvailable"
+	reasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+)
+
+// applyRollout sets the fields of spec.rollout on the podinfo Deployment.
+// Fields that are not set are left out, so they keep the Deployment defaults
+// and no ownership is claimed on them.
+func applyRollout(spec *appsv1ac.DeploymentSpecApplyConfiguration, rollout appv1alpha1.Rollout) {
+	if rollout.MaxSurge != nil || rollout.MaxUnavailable != nil {
+		rollingUpdate := appsv1ac.RollingUpdateDeployment()
+		if rollout.MaxSurge != nil {
+			rollingUpdate.WithMaxSurge(*rollout.MaxSurge)
+		}
+		if rollout.MaxUnavailable != nil {
+			rollingUpdate.WithMaxUnavailable(*rollout.MaxUnavailable)
+		}
+		spec.WithStrategy(appsv1ac.DeploymentStrategy().
+			WithType(appsv1.RollingUpdateDeploymentStrategyType).
+			WithRollingUpdate(rollingUpdate))
+	}
+	if rollout.MinReadySeconds > 0 {
+		spec.WithMinReadySeconds(rollout.MinReadySeconds)
+	}
+	if rollout.ProgressDeadlineSeconds != nil {
+		spec.WithProgressDeadlineSeconds(*rollout.ProgressDeadlineSeconds)
+	}
+}
+
+// rolledBack reports whether the rollout of the current generation of m was
+// rolled back, so the podinfo Deployment keeps the last good pod template.
+func rolledBack(m *appv1alpha1.MyAppResource) bool {
+	rollout := m.Status.Rollout
+	return rollout != nil && rollout.RolledBackGeneration > 0 && rollout.RolledBackGeneration == m.Generation
+}
+
+// trackRollout records the pod template of a completed podinfo rollout as
+// the last good one in status.rollout. When a rollout of the current spec
+// exceeded its progress deadline and spec.rollout.autoRollback is set, the
+// rollback is recorded as well, before the Deployment is applied with the
+// last good template. It reports whether a rollback was started.
+func (r *MyAppResourceReconciler) trackRollout(ctx context.Context, m *appv1alpha1.MyAppResource, d *appsv1.Deployment) (bool, error) {
+	complete, exceeded := rolloutComplete(d), progressDeadlineExceeded(d)
+	if !complete && !exceeded {
+		return false, nil
+	}
+	current, err := r.podinfoReplicaSet(ctx, m, d, func(rs *appsv1.ReplicaSet) bool {
+		return rs.Annotations[revisionAnnotation] == d.Annotations[revisionAnnotation]
+	})
+	if err != nil || current == nil {
+		return false, err
+	}
+	currentHash := current.Labels[appsv1.DefaultDeploymentUniqueLabelKey]
+
+	status := &appv1alpha1.RolloutStatus{}
+	if m.Status.Rollout != nil {
+		status = m.Status.Rollout.DeepCopy()
+	}
+	rollback := false
+	switch {
+	case complete:
+		status.LastGoodTemplateHash = currentHash
+	case !m.Spec.Rollout.AutoRollback, rolledBack(m), m.Generation != m.Status.ObservedGeneration:
+		// Rollbacks are off or done already, or a changed spec gets a rollout
+		// of its own before it is rolled back
+	case status.LastGoodTemplateHash == "" || status.LastGoodTemplateHash == currentHash:
+		r.logger(ctx).Info("Not rolling back Deployment, there is no earlier pod template that rolled out",
+			"Deployment.Namespace", d.Namespace, "Deployment.Name", d.Name)
+	default:
+		condition := getDeploymentCondition(d, appsv1.DeploymentProgressing)
+		status.RolledBackGeneration = m.Generation
+		status.LastRollbackTime = &metav1.Time{Time: r.now()}
+		status.Reason = condition.Reason
+		status.Message = condition.Message
+		rollback = true
+	}
+
+	if equality.Semantic.DeepEqual(m.Status.Rollout, status) {
+		return false, nil
+	}
+	m.Status.Rollout = status
+	if err := r.Status().Update(ctx, m); err != nil {
+		return false, err
+	}
+	return rollback, nil
+}
+
+// lastGoodTemplate returns the pod template of the podinfo ReplicaSet recorded
+// in status.rollout.lastGoodTemplateHash, or nil when it no longer exists.
+func (r *MyAppResourceReconciler) lastGoodTemplate(ctx context.Context, m *appv1alpha1.MyAppResource, d *appsv1.Deployment) (*corev1ac.PodTemplateSpecApplyConfiguration, error) {
+	hash := m.Status.Rollout.LastGoodTemplateHash
+	rs, err := r.podinfoReplicaSet(ctx, m, d, func(rs *appsv1.ReplicaSet) bool {
+		return rs.Labels[appsv1.DefaultDeploymentUniqueLabelKey] == hash
+	})
+	if err != nil || rs == nil {
+		return nil, err
+	}
+
+	// The hash label is added by the Deployment controller, applying the same
+	// template makes it adopt the ReplicaSet again
+	template := rs.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	data, err := json.Marshal(template)
+	if err != nil {
+		return nil, err
+	}
+	ac := &corev1ac.PodTemplateSpecApplyConfiguration{}
+	if err := json.Unmarshal(data, ac); err != nil {
+		return nil, err
+	}
+	return ac, nil
+}
+
+// podinfoReplicaSet returns the ReplicaSet of the podinfo Deployment d that
+// matches, or nil when there is none.
+func (r *MyAppResourceReconciler) podinfoReplicaSet(ctx context.Context, m *appv1alpha1.MyAppResource, d *appsv1.Deployment, match func(*appsv1.ReplicaSet) bool) (*appsv1.ReplicaSet, error) {
+	replicaSets := &appsv1.ReplicaSetList{}
+	if err := r.List(ctx, replicaSets, client.InNamespace(d.Namespace), client.MatchingLabels(labelsForPodinfo(m.Name))); err != nil {
+		return nil, err
+	}
+	for i := range replicaSets.Items {
+		rs := &replicaSets.Items[i]
+		if metav1.IsControlledBy(rs, d) && match(rs) {
+			return rs, nil
+		}
+	}
+	return nil, nil
+}
+
+// rolloutComplete reports whether the latest pod template of d is rolled out.
+func rolloutComplete(d *appsv1.Deployment) bool {
+	c := getDeploymentCondition(d, appsv1.DeploymentProgressing)
+	return d.Status.ObservedGeneration >= d.Generation && c != nil &&
+		c.Status == corev1.ConditionTrue && c.Reason == reasonNewReplicaSetAvailable
+}
+
+// progressDeadlineExceeded reports whether the rollout of the latest pod
+// template of d made no progress within its deadline.
+func progressDeadlineExceeded(d *appsv1.Deployment) bool {
+	c := getDeploymentCondition(d, appsv1.DeploymentProgressing)
+	return d.Status.ObservedGeneration >= d.Generation && c != nil &&
+		c.Status == corev1.ConditionFalse && c.Reason == reasonProgressDeadlineExceeded
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDeploymentForPodinfoRollout(t *testing.T) {
+	m := &appv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default"}}
+	r := &MyAppResourceReconciler{Log: ctrl.Log.WithName("test")}
+
+	deployment, err := r.deploymentForPodinfo(m)
+	require.NoError(t, err)
+	require.Nil(t, deployment.Spec.Strategy)
+	require.Nil(t, deployment.Spec.MinReadySeconds)
+	require.Nil(t, deployment.Spec.ProgressDeadlineSeconds)
+
+	maxUnavailable := intstr.FromInt(0)
+	deadline := int32(120)
+	m.Spec.Rollout = appv1alpha1.Rollout{MaxUnavailable: &maxUnavailable, MinReadySeconds: 10, ProgressDeadlineSeconds: &deadline}
+	deployment, err = r.deploymentForPodinfo(m)
+	require.NoError(t, err)
+	require.Equal(t, appsv1.RollingUpdateDeploymentStrategyType, *deployment.Spec.Strategy.Type)
+	require.Nil(t, deployment.Spec.Strategy.RollingUpdate.MaxSurge)
+	require.Equal(t, maxUnavailable, *deployment.Spec.Strategy.RollingUpdate.MaxUnavailable)
+	require.Equal(t, int32(10), *deployment.Spec.MinReadySeconds)
+	require.Equal(t, int32(120), *deployment.Spec.ProgressDeadlineSeconds)
+}
+
+func TestReconcileRollback(t *testing.T) {
+	ctx := context.Background()
+	m := &appv1alpha1.MyAppResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default", UID: "uid", Generation: 2, Finalizers: []string{finalizerName}},
+		Spec: appv1alpha1.MyAppResourceSpec{
+			ReplicaCount: 2,
+			Image:        appv1alpha1.Image{Repository: "ghcr.io/stefanprodan/podinfo", Tag: "6.5.0"},
+			Rollout:      appv1alpha1.Rollout{AutoRollback: true},
+		},
+		Status: appv1alpha1.MyAppResourceStatus{ObservedGeneration: 2},
+	}
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Name: "example-app-podinfo", Namespace: "default", UID: "deployment-uid",
+		Annotations: map[string]string{revisionAnnotation: "1"},
+	}}
+	deployment.Spec.Template.Spec.Containers = []corev1.Container{{Name: "podinfo", Image: "ghcr.io/stefanprodan/podinfo:6.4.0"}}
+	deployment.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionTrue, Reason: reasonNewReplicaSetAvailable}}
+	replicaSet := func(hash, revision, image string) *appsv1.ReplicaSet {
+		labels := labelsForPodinfo(m.Name)
+		labels[appsv1.DefaultDeploymentUniqueLabelKey] = hash
+		rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name: "example-app-podinfo-" + hash, Namespace: "default", Labels: labels,
+			Annotations:     map[string]string{revisionAnnotation: revision},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+		}}
+		rs.Spec.Template.Labels = labels
+		rs.Spec.Template.Spec.Containers = []corev1.Container{{Name: "podinfo", Image: image}}
+		return rs
+	}
+	good := replicaSet("good", "1", "ghcr.io/stefanprodan/podinfo:6.4.0")
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "example-app-podinfo", Namespace: "default"}}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(m, deployment, service, good).WithStatusSubresource(m, deployment).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &MyAppResourceReconciler{Client: c, Log: ctrl.Log.WithName("test"), Recorder: recorder}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: m.Name, Namespace: m.Namespace}}
+
+	reconcileAndGet := func() (*appv1alpha1.MyAppResource, *appsv1.Deployment) {
+		_, err := r.Reconcile(ctx, req)
+		require.NoError(t, err)
+		found := &appv1alpha1.MyAppResource{}
+		require.NoError(t, c.Get(ctx, req.NamespacedName, found))
+		d := &appsv1.Deployment{}
+		require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "example-app-podinfo", Namespace: "default"}, d))
+		return found, d
+	}
+
+	// The completed rollout is remembered as the last good one
+	found, d := reconcileAndGet()
+	require.Equal(t, "good", found.Status.Rollout.LastGoodTemplateHash)
+	require.Equal(t, "ghcr.io/stefanprodan/podinfo:6.5.0", d.Spec.Template.Spec.Containers[0].Image)
+	drainEvents(recorder)
+
+	// The rollout of 6.5.0 gets stuck
+	d.Annotations[revisionAnnotation] = "2"
+	require.NoError(t, c.Update(ctx, d))
+	d.Status.Conditions = []appsv1.DeploymentCondition{{
+		Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: reasonProgressDeadlineExceeded,
+		Message: `ReplicaSet "example-app-podinfo-bad" has timed out progressing.`,
+	}}
+	require.NoError(t, c.Status().Update(ctx, d))
+	require.NoError(t, c.Create(ctx, replicaSet("bad", "2", "ghcr.io/stefanprodan/podinfo:6.5.0")))
+
+	found, d = reconcileAndGet()
+	require.Equal(t, "ghcr.io/stefanprodan/podinfo:6.4.0", d.Spec.Template.Spec.Containers[0].Image)
+	require.NotContains(t, d.Spec.Template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	require.Equal(t, int64(2), found.Status.Rollout.RolledBackGeneration)
+	require.Equal(t, reasonProgressDeadlineExceeded, found.Status.Rollout.Reason)
+	require.NotNil(t, found.Status.Rollout.LastRollbackTime)
+	require.Equal(t, []string{`Warning RolledBack Rolled back Deployment example-app-podinfo to pod template good: ReplicaSet "example-app-podinfo-bad" has timed out progressing.`}, drainEvents(recorder))
+
+	// The rollback is kept and reported once it completed
+	d.Annotations[revisionAnnotation] = "3"
+	require.NoError(t, c.Update(ctx, d))
+	d.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionTrue, Reason: reasonNewReplicaSetAvailable}}
+	require.NoError(t, c.Status().Update(ctx, d))
+	good.Annotations[revisionAnnotation] = "3"
+	require.NoError(t, c.Update(ctx, good))
+
+	found, d = reconcileAndGet()
+	require.Equal(t, "ghcr.io/stefanprodan/podinfo:6.4.0", d.Spec.Template.Spec.Containers[0].Image)
+	degraded := meta.FindStatusCondition(found.Status.Conditions, appv1alpha1.ConditionDegraded)
+	require.Equal(t, metav1.ConditionTrue, degraded.Status)
+	require.Equal(t, "RolledBack", degraded.Reason)
+	require.Empty(t, drainEvents(recorder))
+
+	// A new spec is rolled out again
+	found.Spec.Image.Tag = "6.5.1"
+	found.Generation = 3
+	require.NoError(t, c.Update(ctx, found))
+	found, d = reconcileAndGet()
+	require.Equal(t, "ghcr.io/stefanprodan/podinfo:6.5.1", d.Spec.Template.Spec.Containers[0].Image)
+	require.Equal(t, metav1.ConditionFalse, meta.FindStatusCondition(found.Status.Conditions, appv1alpha1.ConditionDegraded).Status)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+const podinfoPort = appv1alpha1.DefaultServicePort
+
+// reconcileService makes sure the Service exposing podinfo matches
+// spec.service, and returns the live Service.
+func (r *MyAppResourceReconciler) reconcileService(ctx context.Context, m *appv1alpha1.MyAppResource) (_ *corev1.Service, err error) {
+	ctx, span := r.startSpan(ctx, "service", m)
+	defer func() { endSpan(span, err) }()
+
+	log := r.logger(ctx).WithValues("myappresource", types.NamespacedName{Name: m.Name, Namespace: m.Namespace})
+
+	// Node ports and the ClusterIP allocated by the cluster are not part of
+	// the applied configuration unless spec.service sets them, so they are kept
+	podinfoService := r.serviceForPodinfo(m)
+	podinfoService.WithOwnerReferences(ownerReference(m))
+
+	found := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: *podinfoService.Name, Namespace: m.Namespace}}
+	log.Info("Applying Service", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
+	result, err := r.apply(ctx, found, podinfoService)
+	if err != nil {
+		return nil, err
+	}
+	r.recordApply(m, "Service", found, result)
+	return found, nil
+}
+
+func (r *MyAppResourceReconciler) serviceForPodinfo(m *appv1alpha1.MyAppResource) *corev1ac.ServiceApplyConfiguration {
+	labels := labelsForPodinfo(m.Name)
+	spec := m.Spec.Service
+
+	serviceType := spec.Type
+	if serviceType == "" {
+		serviceType = corev1.ServiceTypeClusterIP
+	}
+	port := spec.Port
+	if port == 0 {
+		port = podinfoPort
+	}
+	sessionAffinity := spec.SessionAffinity
+	if sessionAffinity == "" {
+		sessionAffinity = corev1.ServiceAffinityNone
+	}
+
+	servicePort := corev1ac.ServicePort().
+		WithName("http").
+		WithProtocol(corev1.ProtocolTCP).
+		WithPort(port).
+		WithTargetPort(intstr.FromString("http"))
+	if serviceType != corev1.ServiceTypeClusterIP && spec.NodePort != 0 {
+		servicePort.WithNodePort(spec.NodePort)
+	}
+
+	serviceSpec := corev1ac.ServiceSpec().
+		WithType(serviceType).
+		WithSelector(labels).
+		WithPorts(servicePort).
+		WithSessionAffinity(sessionAffinity)
+	if len(spec.IPFamilies) > 0 {
+		serviceSpec.WithIPFamilies(spec.IPFamilies...)
+	}
+
+	service := corev1ac.Service(m.Name+"-podinfo", m.Namespace).
+		WithLabels(labels).
+		WithSpec(serviceSpec)
+	if len(spec.Annotations) > 0 {
+		service.WithAnnotations(spec.Annotations)
+	}
+	return service
+}
+
+// serviceEndpoint returns the URL under which podinfo is reachable: the load
+// balancer address when one has been assigned, the cluster DNS name otherwise.
+func serviceEndpoint(svc *corev1.Service) string {
+	if svc == nil || len(svc.Spec.Ports) == 0 {
+		return ""
+	}
+	port := svc.Spec.Ports[0].Port
+
+	if svc.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ingress.Hostname != "" {
+				return "http://" + net.JoinHostPort(ingress.Hostname, strconv.Itoa(int(port)))
+			}
+			if ingress.IP != "" {
+				return "http://" + net.JoinHostPort(ingress.IP, strconv.Itoa(int(port)))
+			}
+		}
+	}
+
+	return fmt.Sprintf("http://%s.%s.svc:%d", svc.Name, svc.Namespace, port)
+}
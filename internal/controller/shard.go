@@ -0,0 +1,138 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+// Shard selects the MyAppResources reconciled by one controller replica, so
+// several replicas can each reconcile a disjoint subset. A nil Shard contains
+// every MyAppResource.
+type Shard struct {
+	// Selector restricts the shard to the MyAppResources with matching
+	// labels. The manager cache is filtered with it as well.
+	Selector labels.Selector
+
+	// ID and Count split the MyAppResources by a hash of their namespace and
+	// name, the shard contains those with a hash equal to ID modulo Count.
+	ID    int
+	Count int
+}
+
+// String identifies the shard in status.shard and in the leader election ID,
+// e.g. "team=a" or "1/3".
+func (s *Shard) String() string {
+	if s == nil {
+		return ""
+	}
+	var parts []string
+	if s.Selector != nil && !s.Selector.Empty() {
+		parts = append(parts, s.Selector.String())
+	}
+	if s.Count > 1 {
+		parts = append(parts, fmt.Sprintf("%d/%d", s.ID, s.Count))
+	}
+	return strings.Join(parts, " ")
+}
+
+// LeaderElectionID returns the leader election ID of the shard, derived from
+// base so that only the replicas of the same shard compete for a lease.
+func (s *Shard) LeaderElectionID(base string) string {
+	name := s.String()
+	if name == "" {
+		return base
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return fmt.Sprintf("shard-%08x.%s", h.Sum32(), base)
+}
+
+// Contains reports whether the MyAppResource with the given key and labels
+// belongs to the shard.
+func (s *Shard) Contains(key types.NamespacedName, objLabels map[string]string) bool {
+	if s == nil {
+		return true
+	}
+	if s.Selector != nil && !s.Selector.Matches(labels.Set(objLabels)) {
+		return false
+	}
+	return s.containsKey(key)
+}
+
+// containsKey reports whether key hashes to the shard. Owned objects and
+// ConfigMaps only know the key of the MyAppResource, not its labels, the
+// selector is left to the cache for them.
+func (s *Shard) containsKey(key types.NamespacedName) bool {
+	if s == nil || s.Count <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key.String()))
+	return int(h.Sum32()%uint32(s.Count)) == s.ID
+}
+
+// shardPredicate filters the events of MyAppResources outside of the shard.
+func (r *MyAppResourceReconciler) shardPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return r.Shard.Contains(client.ObjectKeyFromObject(obj), obj.GetLabels())
+	})
+}
+
+// ownerShardPredicate filters the events of children whose MyAppResource
+// hashes to another shard.
+func (r *MyAppResourceReconciler) ownerShardPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		owner := metav1.GetControllerOf(obj)
+		if owner == nil || owner.Kind != "MyAppResource" {
+			return true
+		}
+		return r.Shard.containsKey(types.NamespacedName{Name: owner.Name, Namespace: obj.GetNamespace()})
+	})
+}
+
+// claimShard records the shard in status.shard before anything else is
+// changed. The status update fails with a conflict while the previous shard
+// is still writing to m, and once it went through the previous shard's writes
+// based on older versions of m fail in turn, so the shards do not overlap.
+func (r *MyAppResourceReconciler) claimShard(ctx context.Context, m *appv1alpha1.MyAppResource) error {
+	shard := r.Shard.String()
+	if m.Status.Shard == shard {
+		return nil
+	}
+	previous := m.Status.Shard
+	m.Status.Shard = shard
+	if err := r.Status().Update(ctx, m); err != nil {
+		return err
+	}
+	if previous != "" && shard != "" {
+		r.Recorder.Eventf(m, corev1.EventTypeNormal, reasonShardChanged, "Taken over by shard %q from shard %q", shard, previous)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestShardContains(t *testing.T) {
+	var unsharded *Shard
+	require.True(t, unsharded.Contains(types.NamespacedName{Name: "a", Namespace: "default"}, nil))
+
+	// Every resource belongs to exactly one of the hash shards
+	shards := []*Shard{{ID: 0, Count: 3}, {ID: 1, Count: 3}, {ID: 2, Count: 3}}
+	for i := 0; i < 100; i++ {
+		key := types.NamespacedName{Name: fmt.Sprintf("app-%d", i), Namespace: "default"}
+		owners := 0
+		for _, shard := range shards {
+			if shard.Contains(key, nil) {
+				owners++
+			}
+		}
+		require.Equal(t, 1, owners, key.String())
+	}
+
+	selector, err := labels.Parse("team=a")
+	require.NoError(t, err)
+	shard := &Shard{Selector: selector}
+	require.True(t, shard.Contains(types.NamespacedName{Name: "a", Namespace: "default"}, map[string]string{"team": "a"}))
+	require.False(t, shard.Contains(types.NamespacedName{Name: "a", Namespace: "default"}, map[string]string{"team": "b"}))
+}
+
+func TestShardLeaderElectionID(t *testing.T) {
+	var unsharded *Shard
+	require.Equal(t, "4bd5e203.my.api.group", unsharded.LeaderElectionID("4bd5e203.my.api.group"))
+
+	selector, err := labels.Parse("team=a")
+	require.NoError(t, err)
+	a := &Shard{Selector: selector}
+	require.Equal(t, "team=a", a.String())
+	require.Equal(t, "1/3", (&Shard{ID: 1, Count: 3}).String())
+
+	ids := map[string]bool{}
+	for _, shard := range []*Shard{a, {ID: 0, Count: 3}, {ID: 1, Count: 3}, {Selector: selector, ID: 1, Count: 3}} {
+		id := shard.LeaderElectionID("4bd5e203.my.api.group")
+		require.Regexp(t, `^shard-[0-9a-f]{8}\.4bd5e203\.my\.api\.group$`, id)
+		ids[id] = true
+	}
+	require.Len(t, ids, 4)
+}
+
+func TestClaimShard(t *testing.T) {
+	ctx := context.Background()
+	m := &appv1alpha1.MyAppResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-app", Namespace: "default"},
+		Status:     appv1alpha1.MyAppResourceStatus{Shard: "0/2"},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(m).WithStatusSubresource(m).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &MyAppResourceReconciler{Client: c, Log: ctrl.Log.WithName("test"), Recorder: recorder, Shard: &Shard{ID: 1, Count: 2}}
+
+	current := &appv1alpha1.MyAppResource{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: m.Name, Namespace: m.Namespace}, current))
+	stale := current.DeepCopy()
+
+	require.NoError(t, r.claimShard(ctx, current))
+	require.Equal(t, `Normal ShardChanged Taken over by shard "1/2" from shard "0/2"`, <-recorder.Events)
+
+	found := &appv1alpha1.MyAppResource{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: m.Name, Namespace: m.Namespace}, found))
+	require.Equal(t, "1/2", found.Status.Shard)
+
+	// The previous shard can no longer write based on what it read before
+	stale.Status.ReadyReplicas = 1
+	require.True(t, errors.IsConflict(c.Status().Update(ctx, stale)))
+
+	// Claiming again is a no-op
+	require.NoError(t, r.claimShard(ctx, found))
+	require.Empty(t, recorder.Events)
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+// updateStatus recomputes the observed state of the MyAppResource from the
+// podinfo Deployment and its pods, and writes it through the status subresource
+// when it has changed.
+func (r *MyAppResourceReconciler) updateStatus(ctx context.Context, m *appv1alpha1.MyAppResource, d *appsv1.Deployment, svc *corev1.Service, pods []corev1.Pod) (err error) {
+	ctx, span := r.startSpan(ctx, "status", m)
+	defer func() { endSpan(span, err) }()
+
+	status := m.Status.DeepCopy()
+	// A spec changed while paused is observed once the children are reconciled
+	if pausedBy(m) == "" || syncRequested(m) {
+		status.ObservedGeneration = m.Generation
+	}
+	status.Replicas = d.Status.Replicas
+	status.Selector = labels.SelectorFromSet(labelsForPodinfo(m.Name)).String()
+	if len(d.Spec.Template.Spec.Containers) > 0 {
+		status.Image = d.Spec.Template.Spec.Containers[0].Image
+	}
+	status.DesiredReplicas = m.Spec.ReplicaCount
+	status.ReadyReplicas = d.Status.ReadyReplicas
+	status.UpdatedReplicas = d.Status.UpdatedReplicas
+	status.Endpoint = serviceEndpoint(svc)
+	status.Pods = getPodStatuses(pods)
+	// The changes of a previous dry-run have been made now
+	status.Plan = nil
+
+	setDeploymentConditions(status, d, m.Generation)
+	setPausedCondition(status, m)
+	if value := m.Annotations[syncNowAnnotation]; value != "" {
+		status.LastHandledSyncNow = value
+	}
+
+	cacheCondition, err := r.cacheReadyCondition(ctx, m)
+	if err != nil {
+		return err
+	}
+	meta.SetStatusCondition(&status.Conditions, cacheCondition)
+	recordStatusMetrics(m, status)
+
+	if equality.Semantic.DeepEqual(&m.Status, status) {
+		return nil
+	}
+	m.Status = *status
+	return r.Status().Update(ctx, m)
+}
+
+// markDegraded records a failed reconcile action in the Degraded condition.
+// Errors are only logged, the caller is already returning the original error.
+func (r *MyAppResourceReconciler) markDegraded(ctx context.Context, m *appv1alpha1.MyAppResource, reason string, cause error) {
+	meta.SetStatusCondition(&m.Status.Conditions, metav1.Condition{
+		Type:               appv1alpha1.ConditionDegraded,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason,
+		Message:            cause.Error(),
+		ObservedGeneration: m.Generation,
+	})
+	r.Recorder.Event(m, corev1.EventTypeWarning, reason, cause.Error())
+	if err := r.Status().Update(ctx, m); err != nil {
+		r.logger(ctx).Error(err, "Failed to record Degraded condition", "MyAppResource.Namespace", m.Namespace, "MyAppResource.Name", m.Name)
+	}
+}
+
+// setDeploymentConditions derives the Available, Progressing and Degraded
+// conditions from the podinfo Deployment.
+func setDeploymentConditions(status *appv1alpha1.MyAppResourceStatus, d *appsv1.Deployment, generation int64) {
+	available := metav1.Condition{
+		Type:               appv1alpha1.ConditionAvailable,
+		Status:             metav1.ConditionFalse,
+		Reason:             "MinimumReplicasUnavailable",
+		Message:            "Deployment does not have minimum availability",
+		ObservedGeneration: generation,
+	}
+	if c := getDeploymentCondition(d, appsv1.DeploymentAvailable); c != nil && c.Status == corev1.ConditionTrue {
+		available.Status = metav1.ConditionTrue
+		available.Reason = "MinimumReplicasAvailable"
+		available.Message = "Deployment has minimum availability"
+	}
+	meta.SetStatusCondition(&status.Conditions, available)
+
+	progressing := metav1.Condition{
+		Type:               appv1alpha1.ConditionProgressing,
+		Status:             metav1.ConditionFalse,
+		Reason:             "RolloutComplete",
+		Message:            "All replicas are updated and ready",
+		ObservedGeneration: generation,
+	}
+	if d.Status.ObservedGeneration < d.Generation ||
+		d.Status.UpdatedReplicas < status.DesiredReplicas ||
+		d.Status.ReadyReplicas < status.DesiredReplicas {
+		progressing.Status = metav1.ConditionTrue
+		progressing.Reason = "RolloutInProgress"
+		progressing.Message = "Waiting for replicas to be updated and ready"
+	}
+	meta.SetStatusCondition(&status.Conditions, progressing)
+
+	degraded := metav1.Condition{
+		Type:               appv1alpha1.ConditionDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             "AsExpected",
+		ObservedGeneration: generation,
+	}
+	if c := getDeploymentCondition(d, appsv1.DeploymentProgressing); c != nil && c.Status == corev1.ConditionFalse {
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = c.Reason
+		degraded.Message = c.Message
+	} else if c := getDeploymentCondition(d, appsv1.DeploymentReplicaFailure); c != nil && c.Status == corev1.ConditionTrue {
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = c.Reason
+		degraded.Message = c.Message
+	} else if rollout := status.Rollout; rollout != nil && rollout.RolledBackGeneration > 0 && rollout.RolledBackGeneration == generation {
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = "RolledBack"
+		degraded.Message = "The rollout of the spec failed and was rolled back: " + rollout.Message
+	}
+	meta.SetStatusCondition(&status.Conditions, degraded)
+}
+
+// cacheReadyCondition reports whether the cache backend used by podinfo is ready.
+func (r *MyAppResourceReconciler) cacheReadyCondition(ctx context.Context, m *appv1alpha1.MyAppResource) (metav1.Condition, error) {
+	condition := metav1.Condition{
+		Type:               appv1alpha1.ConditionCacheReady,
+		ObservedGeneration: m.Generation,
+	}
+
+	switch {
+	case m.Spec.Redis.Enabled:
+		redis := &appsv1.Deployment{}
+		err := r.Get(ctx, types.NamespacedName{Name: redisName(m), Namespace: m.Namespace}, redis)
+		if err != nil && !errors.IsNotFound(err) {
+			return condition, err
+		}
+		switch {
+		case errors.IsNotFound(err):
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "RedisNotFound"
+			condition.Message = "Redis Deployment does not exist"
+		case redis.Status.AvailableReplicas > 0:
+			condition.Status = metav1.ConditionTrue
+			condition.Reason = "RedisAvailable"
+			condition.Message = "Redis Deployment has available replicas"
+		default:
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "RedisUnavailable"
+			condition.Message = "Redis Deployment has no available replicas"
+		}
+	case m.Spec.CacheServer.Enabled:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "ExternalCacheServer"
+		condition.Message = "Using an external cache server"
+	default:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "CacheDisabled"
+		condition.Message = "No cache is configured"
+	}
+
+	return condition, nil
+}
+
+// getPodStatuses converts pods into status entries, sorted by name so that
+// the status does not change between reconciles when the pods do not.
+func getPodStatuses(pods []corev1.Pod) []appv1alpha1.PodStatus {
+	var podStatuses []appv1alpha1.PodStatus
+	for _, pod := range pods {
+		podStatus := appv1alpha1.PodStatus{
+			Name:     pod.Name,
+			NodeName: pod.Spec.NodeName,
+			Phase:    pod.Status.Phase,
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			podStatus.Restarts += cs.RestartCount
+		}
+		for _, c := range pod.Status.Conditions {
+			if c.Type == corev1.PodReady {
+				podStatus.Ready = c.Status == corev1.ConditionTrue
+			}
+		}
+		podStatuses = append(podStatuses, podStatus)
+	}
+	sort.Slice(podStatuses, func(i, j int) bool {
+		return podStatuses[i].Name < podStatuses[j].Name
+	})
+	return podStatuses
+}
+
+func getDeploymentCondition(d *appsv1.Deployment, conditionType appsv1.DeploymentConditionType) *appsv1.DeploymentCondition {
+	for i := range d.Status.Conditions {
+		if d.Status.Conditions[i].Type == conditionType {
+			return &d.Status.Conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetPodStatuses(t *testing.T) {
+	pods := []corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-b"},
+			Spec:       corev1.PodSpec{NodeName: "node-2"},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodPending,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-a"},
+			Spec:       corev1.PodSpec{NodeName: "node-1"},
+			Status: corev1.PodStatus{
+				Phase:             corev1.PodRunning,
+				Conditions:        []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+				ContainerStatuses: []corev1.ContainerStatus{{RestartCount: 2}, {RestartCount: 1}},
+			},
+		},
+	}
+
+	require.Equal(t, []appv1alpha1.PodStatus{
+		{Name: "podinfo-a", NodeName: "node-1", Phase: corev1.PodRunning, Restarts: 3, Ready: true},
+		{Name: "podinfo-b", NodeName: "node-2", Phase: corev1.PodPending, Restarts: 0, Ready: false},
+	}, getPodStatuses(pods))
+}
+
+func TestSetDeploymentConditions(t *testing.T) {
+	status := &appv1alpha1.MyAppResourceStatus{DesiredReplicas: 2}
+	d := &appsv1.Deployment{
+		Status: appsv1.DeploymentStatus{
+			ReadyReplicas:   1,
+			UpdatedReplicas: 2,
+			Conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionTrue},
+				{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded"},
+			},
+		},
+	}
+
+	setDeploymentConditions(status, d, 1)
+
+	require.True(t, meta.IsStatusConditionTrue(status.Conditions, appv1alpha1.ConditionAvailable))
+	require.True(t, meta.IsStatusConditionTrue(status.Conditions, appv1alpha1.ConditionProgressing))
+	degraded := meta.FindStatusCondition(status.Conditions, appv1alpha1.ConditionDegraded)
+	require.NotNil(t, degraded)
+	require.Equal(t, metav1.ConditionTrue, degraded.Status)
+	require.Equal(t, "ProgressDeadlineExceeded", degraded.Reason)
+}
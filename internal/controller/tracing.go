@@ -0,0 +1,176 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+)
+
+// tracerName is the instrumentation scope of the spans of the controller.
+const tracerName = "github.com/sumyann/k8s-controller/internal/controller"
+
+// tracer returns the tracer of the reconciler. Without a TracerProvider the
+// global one is used, which does not record anything unless tracing is set up.
+func (r *MyAppResourceReconciler) tracer() trace.Tracer {
+	tp := r.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// startSpan starts a span for one component of the reconcile of m.
+func (r *MyAppResourceReconciler) startSpan(ctx context.Context, name string, m *appv1alpha1.MyAppResource) (context.Context, trace.Span) {
+	return r.tracer().Start(ctx, name, trace.WithAttributes(
+		attribute.String("myappresource.namespace", m.Namespace),
+		attribute.String("myappresource.name", m.Name),
+	))
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// logger returns the logger of the reconciler with the IDs of the span in ctx,
+// so log lines can be matched with their trace.
+func (r *MyAppResourceReconciler) logger(ctx context.Context) logr.Logger {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return r.Log
+	}
+	return r.Log.WithValues("traceID", sc.TraceID().String(), "spanID", sc.SpanID().String())
+}
+
+// tracingClient starts a span for every call to the API server, or the cache
+// for reads, as a child of the span in the context of the call.
+type tracingClient struct {
+	client.Client
+	tracer trace.Tracer
+}
+
+// newTracingClient wraps c so that its calls are traced with tracer.
+func newTracingClient(c client.Client, tracer trace.Tracer) client.Client {
+	return &tracingClient{Client: c, tracer: tracer}
+}
+
+func (c *tracingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) (err error) {
+	ctx, span := c.start(ctx, "Get", obj, key)
+	defer func() { c.end(span, err) }()
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *tracingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) (err error) {
+	listOpts := (&client.ListOptions{}).ApplyOptions(opts)
+	ctx, span := c.start(ctx, "List", list, client.ObjectKey{Namespace: listOpts.Namespace})
+	defer func() { c.end(span, err) }()
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *tracingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) (err error) {
+	ctx, span := c.start(ctx, "Create", obj, client.ObjectKeyFromObject(obj))
+	defer func() { c.end(span, err) }()
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *tracingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) (err error) {
+	ctx, span := c.start(ctx, "Update", obj, client.ObjectKeyFromObject(obj))
+	defer func() { c.end(span, err) }()
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *tracingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) (err error) {
+	ctx, span := c.start(ctx, "Patch", obj, client.ObjectKeyFromObject(obj))
+	span.SetAttributes(attribute.String("k8s.patch.type", string(patch.Type())))
+	defer func() { c.end(span, err) }()
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *tracingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) (err error) {
+	ctx, span := c.start(ctx, "Delete", obj, client.ObjectKeyFromObject(obj))
+	defer func() { c.end(span, err) }()
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *tracingClient) Status() client.SubResourceWriter {
+	return &tracingStatusWriter{SubResourceWriter: c.Client.Status(), client: c}
+}
+
+// tracingStatusWriter traces writes to the status subresource.
+type tracingStatusWriter struct {
+	client.SubResourceWriter
+	client *tracingClient
+}
+
+func (w *tracingStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) (err error) {
+	ctx, span := w.client.start(ctx, "UpdateStatus", obj, client.ObjectKeyFromObject(obj))
+	defer func() { w.client.end(span, err) }()
+	return w.SubResourceWriter.Update(ctx, obj, opts...)
+}
+
+func (w *tracingStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) (err error) {
+	ctx, span := w.client.start(ctx, "PatchStatus", obj, client.ObjectKeyFromObject(obj))
+	span.SetAttributes(attribute.String("k8s.patch.type", string(patch.Type())))
+	defer func() { w.client.end(span, err) }()
+	return w.SubResourceWriter.Patch(ctx, obj, patch, opts...)
+}
+
+// start starts a client span named after the verb and the kind of obj, such
+// as "Get Deployment".
+func (c *tracingClient) start(ctx context.Context, verb string, obj runtime.Object, key client.ObjectKey) (context.Context, trace.Span) {
+	kind := ""
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		kind = gvk.Kind
+	}
+	attributes := []attribute.KeyValue{attribute.String("k8s.kind", kind)}
+	if key.Namespace != "" {
+		attributes = append(attributes, attribute.String("k8s.namespace", key.Namespace))
+	}
+	if key.Name != "" {
+		attributes = append(attributes, attribute.String("k8s.name", key.Name))
+	}
+	return c.tracer.Start(ctx, verb+" "+kind, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+}
+
+// end ends a client span. The reason of a failure tells conflicts apart from
+// other errors, not found is an expected outcome and not marked as an error.
+func (c *tracingClient) end(span trace.Span, err error) {
+	if err != nil {
+		span.SetAttributes(attribute.String("k8s.error.reason", string(errors.ReasonForError(err))))
+		if !errors.IsNotFound(err) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	span.End()
+}
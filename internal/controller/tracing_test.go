@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/stretchr/testify/require"
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// tracedReconciler returns a reconciler recording its spans in the returned
+// exporter, with the client calls traced as in SetupWithManager.
+func tracedReconciler(c client.Client) (*MyAppResourceReconciler, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	r := &MyAppResourceReconciler{
+		Client:         c,
+		Log:            ctrl.Log.WithName("test"),
+		Recorder:       record.NewFakeRecorder(10),
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+	}
+	r.Client = newTracingClient(c, r.tracer())
+	return r, exporter
+}
+
+func spansByName(spans tracetest.SpanStubs) map[string]tracetest.SpanStub {
+	byName := make(map[string]tracetest.SpanStub, len(spans))
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	return byName
+}
+
+func TestReconcileSpans(t *testing.T) {
+	m := deletedMyAppResource("")
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(m).WithStatusSubresource(m).Build()
+	r, exporter := tracedReconciler(c)
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: m.Name, Namespace: m.Namespace}})
+	require.NoError(t, err)
+
+	spans := spansByName(exporter.GetSpans())
+	require.Len(t, spans, 4)
+	reconcile, get, finalize, update := spans["Reconcile"], spans["Get MyAppResource"], spans["finalize"], spans["Update MyAppResource"]
+
+	require.False(t, reconcile.Parent.IsValid())
+	require.Equal(t, reconcile.SpanContext.SpanID(), get.Parent.SpanID())
+	require.Equal(t, reconcile.SpanContext.SpanID(), finalize.Parent.SpanID())
+	require.Equal(t, finalize.SpanContext.SpanID(), update.Parent.SpanID())
+	for _, span := range spans {
+		require.Equal(t, reconcile.SpanContext.TraceID(), span.SpanContext.TraceID())
+		require.Equal(t, codes.Unset, span.Status.Code, span.Name)
+	}
+}
+
+func TestReconcileSpansRecordErrors(t *testing.T) {
+	m := deletedMyAppResource("")
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(m).WithStatusSubresource(m).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				return errors.NewConflict(schema.GroupResource{Resource: "myappresources"}, obj.GetName(), nil)
+			},
+		}).Build()
+	r, exporter := tracedReconciler(c)
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: m.Name, Namespace: m.Namespace}})
+	require.Error(t, err)
+
+	spans := spansByName(exporter.GetSpans())
+	require.Equal(t, codes.Error, spans["Reconcile"].Status.Code)
+	require.Equal(t, codes.Error, spans["Update MyAppResource"].Status.Code)
+	require.Contains(t, spans["Update MyAppResource"].Attributes, attribute.String("k8s.error.reason", "Conflict"))
+}
+
+func TestTracingClientNotFound(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	r, exporter := tracedReconciler(c)
+
+	err := r.Get(context.Background(), types.NamespacedName{Name: "missing", Namespace: "default"}, &appv1alpha1.MyAppResource{})
+	require.True(t, errors.IsNotFound(err))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	require.Equal(t, "Get MyAppResource", spans[0].Name)
+	require.Equal(t, codes.Unset, spans[0].Status.Code)
+	require.Contains(t, spans[0].Attributes, attribute.String("k8s.name", "missing"))
+	require.Contains(t, spans[0].Attributes, attribute.String("k8s.error.reason", "NotFound"))
+}
+
+func TestLoggerTraceID(t *testing.T) {
+	var lines []string
+	r, _ := tracedReconciler(fake.NewClientBuilder().WithScheme(scheme).Build())
+	r.Log = funcr.New(func(prefix, args string) { lines = append(lines, args) }, funcr.Options{})
+
+	r.logger(context.Background()).Info("no span")
+	ctx, span := r.tracer().Start(context.Background(), "test")
+	r.logger(ctx).Info("in span")
+	span.End()
+
+	require.Len(t, lines, 2)
+	require.NotContains(t, lines[0], "traceID")
+	require.True(t, strings.Contains(lines[1], `"traceID"="`+span.SpanContext().TraceID().String()+`"`), lines[1])
+	require.True(t, strings.Contains(lines[1], `"spanID"="`+span.SpanContext().SpanID().String()+`"`), lines[1])
+}
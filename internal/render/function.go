@@ -0,0 +1,128 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"github.com/sumyann/k8s-controller/internal/controller"
+)
+
+const (
+	resourceListAPIVersion = "config.kubernetes.io/v1"
+	resourceListKind       = "ResourceList"
+)
+
+// resourceList is the input and output of a KRM function, see
+// https://github.com/kubernetes-sigs/kustomize/blob/master/cmd/config/docs/api-conventions/functions-spec.md
+type resourceList struct {
+	APIVersion     string                   `json:"apiVersion"`
+	Kind           string                   `json:"kind"`
+	Items          []map[string]interface{} `json:"items"`
+	FunctionConfig map[string]interface{}   `json:"functionConfig,omitempty"`
+	Results        []result                 `json:"results,omitempty"`
+}
+
+type result struct {
+	Message     string       `json:"message"`
+	Severity    string       `json:"severity"`
+	ResourceRef *resourceRef `json:"resourceRef,omitempty"`
+}
+
+type resourceRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace,omitempty"`
+}
+
+func isResourceList(doc map[string]interface{}) bool {
+	return doc["kind"] == resourceListKind
+}
+
+// runFunction renders the MyAppResources among the items of a ResourceList
+// and appends their children to the items. The input items are kept, so the
+// function can run as a kustomize generator. Invalid MyAppResources are
+// reported as error results and ErrInvalid is returned after the
+// ResourceList has been written.
+func runFunction(r *controller.MyAppResourceReconciler, doc map[string]interface{}, out io.Writer) ([]Warning, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	list := &resourceList{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, fmt.Errorf("invalid ResourceList: %w", err)
+	}
+
+	var (
+		warnings []Warning
+		invalid  bool
+		items    = list.Items
+	)
+	for _, item := range list.Items {
+		ref := refOf(item)
+		m, err := myAppResource(item)
+		if err == nil && m == nil {
+			continue
+		}
+		if err == nil {
+			var objects []map[string]interface{}
+			var w []Warning
+			objects, w, err = render(r, m)
+			warnings = append(warnings, w...)
+			for _, warning := range w {
+				list.Results = append(list.Results, result{Message: warning.Message, Severity: "warning", ResourceRef: ref})
+			}
+			items = append(items, objects...)
+		}
+		if err != nil {
+			invalid = true
+			list.Results = append(list.Results, result{Message: err.Error(), Severity: "error", ResourceRef: ref})
+		}
+	}
+
+	list.APIVersion = resourceListAPIVersion
+	list.Kind = resourceListKind
+	list.Items = items
+	data, err = yaml.Marshal(list)
+	if err != nil {
+		return warnings, err
+	}
+	if _, err := out.Write(data); err != nil {
+		return warnings, err
+	}
+	if invalid {
+		return warnings, ErrInvalid
+	}
+	return warnings, nil
+}
+
+func refOf(item map[string]interface{}) *resourceRef {
+	u := &unstructured.Unstructured{Object: item}
+	return &resourceRef{
+		APIVersion: u.GetAPIVersion(),
+		Kind:       u.GetKind(),
+		Name:       u.GetName(),
+		Namespace:  u.GetNamespace(),
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package render prints the objects the controller creates for MyAppResource
+// manifests, without a cluster. It reads plain YAML documents, or a KRM
+// function ResourceList so it can run inside kustomize.
+package render
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	appv1alpha1 "github.com/sumyann/k8s-controller/api/v1alpha1"
+	appv1beta1 "github.com/sumyann/k8s-controller/api/v1beta1"
+	"github.com/sumyann/k8s-controller/internal/controller"
+)
+
+// ErrInvalid is returned when an input MyAppResource could not be rendered.
+// In KRM mode the ResourceList is still written, with the errors in results.
+var ErrInvalid = errors.New("invalid MyAppResources")
+
+// Warning is an admission warning of an input MyAppResource.
+type Warning struct {
+	Namespace, Name string
+	Message         string
+}
+
+// Run reads MyAppResource manifests from in and writes the rendered children
+// to out. Input holding a single ResourceList is handled as a KRM function
+// call and answered with a ResourceList, other input is answered with a YAML
+// stream of the children.
+func Run(r *controller.MyAppResourceReconciler, in io.Reader, out io.Writer) ([]Warning, error) {
+	docs, err := decode(in)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 1 && isResourceList(docs[0]) {
+		return runFunction(r, docs[0], out)
+	}
+
+	var warnings []Warning
+	for _, doc := range docs {
+		m, err := myAppResource(doc)
+		if err != nil {
+			return warnings, err
+		}
+		if m == nil {
+			continue
+		}
+		objects, w, err := render(r, m)
+		warnings = append(warnings, w...)
+		if err != nil {
+			return warnings, err
+		}
+		for _, obj := range objects {
+			data, err := yaml.Marshal(obj)
+			if err != nil {
+				return warnings, err
+			}
+			if _, err := fmt.Fprintf(out, "---\n%s", data); err != nil {
+				return warnings, err
+			}
+		}
+	}
+	return warnings, nil
+}
+
+// render defaults and validates m like the admission webhook does, and
+// renders its children as they would be applied.
+func render(r *controller.MyAppResourceReconciler, m *appv1alpha1.MyAppResource) ([]map[string]interface{}, []Warning, error) {
+	m.DefaultFrom(r.Config.Get().Defaults)
+	admissionWarnings, err := m.ValidateCreate()
+	var warnings []Warning
+	for _, warning := range admissionWarnings {
+		warnings = append(warnings, Warning{Namespace: m.Namespace, Name: m.Name, Message: warning})
+	}
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	applyConfigurations, err := r.Render(m)
+	if err != nil {
+		return nil, warnings, fmt.Errorf("MyAppResource %s: %w", objectName(m.Namespace, m.Name), err)
+	}
+	objects := make([]map[string]interface{}, 0, len(applyConfigurations))
+	for _, ac := range applyConfigurations {
+		data, err := json.Marshal(ac)
+		if err != nil {
+			return nil, warnings, err
+		}
+		obj := map[string]interface{}{}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, warnings, err
+		}
+		// Resources without a namespace get the one of kustomize or kubectl
+		if m.Namespace == "" {
+			unstructured.RemoveNestedField(obj, "metadata", "namespace")
+		}
+		objects = append(objects, obj)
+	}
+	return objects, warnings, nil
+}
+
+// myAppResource converts a MyAppResource document of any served version to
+// v1alpha1, the version the controller works with. It returns nil for other
+// documents.
+func myAppResource(doc map[string]interface{}) (*appv1alpha1.MyAppResource, error) {
+	gvk := (&unstructured.Unstructured{Object: doc}).GroupVersionKind()
+	if gvk.Group != appv1alpha1.GroupVersion.Group || gvk.Kind != "MyAppResource" {
+		return nil, nil
+	}
+
+	m := &appv1alpha1.MyAppResource{}
+	switch gvk.Version {
+	case appv1alpha1.GroupVersion.Version:
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(doc, m); err != nil {
+			return nil, err
+		}
+	case appv1beta1.GroupVersion.Version:
+		hub := &appv1beta1.MyAppResource{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(doc, hub); err != nil {
+			return nil, err
+		}
+		if err := m.ConvertFrom(hub); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported version %s of MyAppResource", gvk.GroupVersion())
+	}
+	return m, nil
+}
+
+// decode reads all YAML or JSON documents of in, skipping empty ones.
+func decode(in io.Reader) ([]map[string]interface{}, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(in, 4096)
+	var docs []map[string]interface{}
+	for {
+		doc := map[string]interface{}{}
+		if err := decoder.Decode(&doc); err == io.EOF {
+			return docs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(doc) > 0 {
+			docs = append(docs, doc)
+		}
+	}
+}
+
+func objectName(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
@@ -0,0 +1,165 @@
+package render
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+
+	"github.com/sumyann/k8s-controller/internal/controller"
+)
+
+const testResources = `
+apiVersion: my.api.group.my.api.group/v1alpha1
+kind: MyAppResource
+metadata:
+  name: alpha
+  namespace: production
+spec:
+  replicaCount: 2
+  redis:
+    enabled: true
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unrelated
+---
+apiVersion: my.api.group.my.api.group/v1beta1
+kind: MyAppResource
+metadata:
+  name: beta
+spec:
+  replicaCount: 1
+  podinfo:
+    image:
+      repository: registry.example.com/podinfo
+      tag: "6.5.0"
+`
+
+func testReconciler() *controller.MyAppResourceReconciler {
+	return &controller.MyAppResourceReconciler{Log: logr.Discard()}
+}
+
+func kindsAndNames(t *testing.T, data []byte) []string {
+	var out []string
+	for _, doc := range strings.Split(string(data), "---\n") {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		obj := map[string]interface{}{}
+		require.NoError(t, yaml.Unmarshal([]byte(doc), &obj))
+		out = append(out, objectKey(obj))
+	}
+	return out
+}
+
+func objectKey(obj map[string]interface{}) string {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	if namespace, ok := metadata["namespace"].(string); ok {
+		name = namespace + "/" + name
+	}
+	return obj["kind"].(string) + " " + name
+}
+
+func TestRun(t *testing.T) {
+	var out bytes.Buffer
+	warnings, err := Run(testReconciler(), strings.NewReader(testResources), &out)
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+
+	require.Equal(t, []string{
+		"Deployment production/alpha-redis",
+		"Service production/alpha-redis",
+		"Service production/alpha-podinfo",
+		"Deployment production/alpha-podinfo",
+		"Service beta-podinfo",
+		"Deployment beta-podinfo",
+	}, kindsAndNames(t, out.Bytes()))
+	// v1beta1 input is converted before rendering
+	require.Contains(t, out.String(), "image: registry.example.com/podinfo:6.5.0")
+}
+
+func TestRunInvalid(t *testing.T) {
+	input := `
+apiVersion: my.api.group.my.api.group/v1alpha1
+kind: MyAppResource
+metadata:
+  name: invalid
+spec:
+  replicaCount: -1
+`
+	var out bytes.Buffer
+	_, err := Run(testReconciler(), strings.NewReader(input), &out)
+	require.ErrorContains(t, err, "spec.replicaCount")
+	require.Empty(t, out.String())
+}
+
+func TestRunFunction(t *testing.T) {
+	var items []interface{}
+	for _, doc := range strings.Split(testResources, "---\n") {
+		item := map[string]interface{}{}
+		require.NoError(t, yaml.Unmarshal([]byte(doc), &item))
+		items = append(items, item)
+	}
+	input, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "config.kubernetes.io/v1",
+		"kind":       "ResourceList",
+		"items":      items,
+	})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	_, err = Run(testReconciler(), bytes.NewReader(input), &out)
+	require.NoError(t, err)
+
+	list := &resourceList{}
+	require.NoError(t, yaml.Unmarshal(out.Bytes(), list))
+	require.Equal(t, "ResourceList", list.Kind)
+	require.Empty(t, list.Results)
+	var keys []string
+	for _, item := range list.Items {
+		keys = append(keys, objectKey(item))
+	}
+	// The input items are kept and the children appended
+	require.Equal(t, []string{
+		"MyAppResource production/alpha",
+		"ConfigMap unrelated",
+		"MyAppResource beta",
+		"Deployment production/alpha-redis",
+		"Service production/alpha-redis",
+		"Service production/alpha-podinfo",
+		"Deployment production/alpha-podinfo",
+		"Service beta-podinfo",
+		"Deployment beta-podinfo",
+	}, keys)
+}
+
+func TestRunFunctionInvalid(t *testing.T) {
+	input := `
+apiVersion: config.kubernetes.io/v1
+kind: ResourceList
+items:
+- apiVersion: my.api.group.my.api.group/v1alpha1
+  kind: MyAppResource
+  metadata:
+    name: invalid
+  spec:
+    replicaCount: -1
+`
+	var out bytes.Buffer
+	_, err := Run(testReconciler(), strings.NewReader(input), &out)
+	require.ErrorIs(t, err, ErrInvalid)
+
+	list := &resourceList{}
+	require.NoError(t, yaml.Unmarshal(out.Bytes(), list))
+	require.Len(t, list.Items, 1)
+	require.Len(t, list.Results, 1)
+	require.Equal(t, "error", list.Results[0].Severity)
+	require.Equal(t, "invalid", list.Results[0].ResourceRef.Name)
+	require.Contains(t, list.Results[0].Message, "spec.replicaCount")
+}